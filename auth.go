@@ -0,0 +1,513 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// sessionCookieName is the name of the UI session cookie
+const sessionCookieName = "gjallarhorn_session"
+
+// sessionTTL is how long a UI session stays valid
+const sessionTTL = 7 * 24 * time.Hour
+
+// Login throttling: after maxLoginFailures failed attempts from one address
+// within loginFailureWindow, further attempts are rejected until the window passes
+const (
+	maxLoginFailures   = 5
+	loginFailureWindow = 15 * time.Minute
+	maxLoginAddrs      = 10000 // Addresses tracked at once; the oldest is dropped beyond this
+)
+
+// principalContextKey is the echo context key holding the authenticated Principal
+const principalContextKey = "principal"
+
+// Principal identifies the caller of an authenticated request
+type Principal struct {
+	Name   string
+	Method string   // "session", "token", "key" or "proxy"
+	Scopes []string // Granted scopes; ScopeAdmin grants everything
+}
+
+// HasScope reports whether the principal was granted a scope
+func (p *Principal) HasScope(scope string) bool {
+	for _, granted := range p.Scopes {
+		if granted == ScopeAdmin || granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// roleScopes maps Gjallarhorn roles to the scopes they grant
+var roleScopes = map[string][]string{
+	"admin":  {ScopeAdmin},
+	"editor": {ScopeRead, ScopeServicesWrite},
+	"viewer": {ScopeRead},
+}
+
+// roleMapping maps external groups to Gjallarhorn roles
+type roleMapping struct {
+	groups      map[string][]string // role -> groups granting it
+	defaultRole string              // role for users matching no group; empty denies them
+}
+
+// newRoleMapping reads <prefix>ADMIN_GROUPS, <prefix>EDITOR_GROUPS,
+// <prefix>VIEWER_GROUPS and <prefix>DEFAULT_ROLE from the environment
+func newRoleMapping(prefix, fallbackRole string) *roleMapping {
+	defaultRole := fallbackRole
+	if value, set := os.LookupEnv(prefix + "DEFAULT_ROLE"); set {
+		defaultRole = value
+	}
+	if _, ok := roleScopes[defaultRole]; defaultRole != "" && !ok {
+		log.Printf("Warning: Ignoring unknown %sDEFAULT_ROLE '%s'", prefix, defaultRole)
+		defaultRole = ""
+	}
+
+	return &roleMapping{
+		groups: map[string][]string{
+			"admin":  splitList(os.Getenv(prefix + "ADMIN_GROUPS")),
+			"editor": splitList(os.Getenv(prefix + "EDITOR_GROUPS")),
+			"viewer": splitList(os.Getenv(prefix + "VIEWER_GROUPS")),
+		},
+		defaultRole: defaultRole,
+	}
+}
+
+// scopesForGroups maps the groups of a user to the scopes of their highest role
+func (r *roleMapping) scopesForGroups(groups []string) []string {
+	member := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		member[group] = true
+	}
+
+	for _, role := range []string{"admin", "editor", "viewer"} {
+		for _, group := range r.groups[role] {
+			if member[group] {
+				return roleScopes[role]
+			}
+		}
+	}
+
+	if r.defaultRole != "" {
+		return roleScopes[r.defaultRole]
+	}
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// session represents a logged-in UI session
+type session struct {
+	username  string
+	scopes    []string
+	expiresAt time.Time
+}
+
+// loginFailures tracks failed login attempts from one address
+type loginFailures struct {
+	count int
+	first time.Time
+}
+
+// AuthService handles authentication for the API and UI
+type AuthService struct {
+	config   *AuthConfig
+	apiToken string
+	keys     []*APIKey
+	oidc     *OIDCAuth
+	proxy    *ProxyAuth
+	sessions map[string]*session
+	failures map[string]*loginFailures
+	mu       sync.RWMutex
+	storage  Store
+}
+
+// NewAuthService creates a new auth service.
+// The admin user is loaded from storage, or seeded from ADMIN_USERNAME and
+// ADMIN_PASSWORD (or ADMIN_PASSWORD_HASH) on first start.
+func NewAuthService(storage Store) *AuthService {
+	// A corrupt auth file must not silently disable authentication
+	config, err := storage.LoadAuthConfig()
+	if err != nil {
+		log.Fatalf("Failed to load auth config from storage: %v", err)
+	}
+
+	if config == nil {
+		config = seedAuthConfig()
+		if config != nil {
+			if err := storage.SaveAuthConfig(config); err != nil {
+				log.Printf("Warning: Failed to save auth config to storage: %v", err)
+			}
+		}
+	}
+
+	apiToken := os.Getenv("API_TOKEN")
+
+	keys, err := storage.LoadAPIKeys()
+	if err != nil {
+		log.Fatalf("Failed to load API keys from storage: %v", err)
+	}
+
+	oidcAuth := NewOIDCAuth()
+	proxyAuth := NewProxyAuth()
+
+	if config == nil && apiToken == "" && oidcAuth == nil && proxyAuth == nil {
+		log.Println("Warning: Authentication is disabled. Set ADMIN_PASSWORD to protect the API before exposing Gjallarhorn beyond localhost")
+	}
+
+	return &AuthService{
+		config:   config,
+		apiToken: apiToken,
+		keys:     keys,
+		oidc:     oidcAuth,
+		proxy:    proxyAuth,
+		sessions: make(map[string]*session),
+		failures: make(map[string]*loginFailures),
+		storage:  storage,
+	}
+}
+
+// seedAuthConfig builds the admin credentials from environment variables
+func seedAuthConfig() *AuthConfig {
+	username := os.Getenv("ADMIN_USERNAME")
+	if username == "" {
+		username = "admin"
+	}
+
+	if hash := os.Getenv("ADMIN_PASSWORD_HASH"); hash != "" {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			log.Printf("Warning: Ignoring invalid ADMIN_PASSWORD_HASH: %v", err)
+			return nil
+		}
+		return &AuthConfig{Username: username, PasswordHash: hash}
+	}
+
+	if password := os.Getenv("ADMIN_PASSWORD"); password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			log.Printf("Warning: Failed to hash ADMIN_PASSWORD: %v", err)
+			return nil
+		}
+		return &AuthConfig{Username: username, PasswordHash: string(hash)}
+	}
+
+	return nil
+}
+
+// Enabled reports whether authentication is enforced
+func (a *AuthService) Enabled() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.config != nil || a.apiToken != "" || a.oidc != nil || a.proxy != nil
+}
+
+// authenticate resolves the principal of a request from its bearer token,
+// trusted proxy header or session cookie
+func (a *AuthService) authenticate(c echo.Context) *Principal {
+	if header := c.Request().Header.Get(echo.HeaderAuthorization); strings.HasPrefix(header, "Bearer ") {
+		token := strings.TrimPrefix(header, "Bearer ")
+		if isAPIKey(token) {
+			return a.authenticateAPIKey(token)
+		}
+		if a.apiToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.apiToken)) == 1 {
+			return &Principal{Name: "api-token", Method: "token", Scopes: []string{ScopeAdmin}}
+		}
+		return nil
+	}
+
+	if a.proxy != nil {
+		if principal := a.proxy.authenticate(c); principal != nil {
+			return principal
+		}
+	}
+
+	cookie, err := c.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	sess, exists := a.sessions[cookie.Value]
+	if !exists {
+		return nil
+	}
+	if time.Now().After(sess.expiresAt) {
+		delete(a.sessions, cookie.Value)
+		return nil
+	}
+	return &Principal{Name: sess.username, Method: "session", Scopes: sess.scopes}
+}
+
+// RequireAuth is middleware that rejects unauthenticated requests when authentication is enabled
+func (a *AuthService) RequireAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !a.Enabled() {
+			return next(c)
+		}
+
+		principal := a.authenticate(c)
+		if principal == nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "authentication required"})
+		}
+
+		c.Set(principalContextKey, principal)
+		return next(c)
+	}
+}
+
+// checkLoginThrottleLocked reports whether an address may attempt to log in, assuming the lock is held
+func (a *AuthService) checkLoginThrottleLocked(addr string) bool {
+	failures, exists := a.failures[addr]
+	if !exists {
+		return true
+	}
+	if time.Since(failures.first) > loginFailureWindow {
+		delete(a.failures, addr)
+		return true
+	}
+	return failures.count < maxLoginFailures
+}
+
+// recordLoginFailureLocked counts a failed login attempt, assuming the lock is held
+func (a *AuthService) recordLoginFailureLocked(addr string) {
+	failures, exists := a.failures[addr]
+	if !exists {
+		if len(a.failures) >= maxLoginAddrs {
+			a.evictLoginFailuresLocked()
+		}
+		a.failures[addr] = &loginFailures{count: 1, first: time.Now()}
+		return
+	}
+	failures.count++
+}
+
+// evictLoginFailuresLocked drops the addresses whose window has passed, or the oldest one
+// when all are still throttled, assuming the lock is held
+func (a *AuthService) evictLoginFailuresLocked() {
+	var oldest string
+	for addr, failures := range a.failures {
+		if time.Since(failures.first) > loginFailureWindow {
+			delete(a.failures, addr)
+			continue
+		}
+		if oldest == "" || failures.first.Before(a.failures[oldest].first) {
+			oldest = addr
+		}
+	}
+	if len(a.failures) >= maxLoginAddrs {
+		delete(a.failures, oldest)
+	}
+}
+
+// startSession creates a UI session and returns its token
+func (a *AuthService) startSession(username string, scopes []string) (string, error) {
+	token, err := generateToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	a.sessions[token] = &session{username: username, scopes: scopes, expiresAt: time.Now().Add(sessionTTL)}
+	a.mu.Unlock()
+
+	return token, nil
+}
+
+// newSessionCookie creates the session cookie for a token
+func newSessionCookie(c echo.Context, token string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// Login authenticates the admin user and starts a session
+// @Summary Log in
+// @Description Authenticates the admin user and sets a session cookie
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param credentials body LoginRequest true "Admin credentials"
+// @Success 200 {object} AuthStatusResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /auth/login [post]
+func (a *AuthService) Login(c echo.Context) error {
+	var req LoginRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format: " + err.Error()})
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Validation failed: " + err.Error()})
+	}
+
+	addr := c.RealIP()
+
+	a.mu.Lock()
+	if !a.checkLoginThrottleLocked(addr) {
+		a.mu.Unlock()
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "too many failed login attempts, try again later"})
+	}
+	config := a.config
+	a.mu.Unlock()
+
+	if config == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "no admin user is configured"})
+	}
+
+	// Always run bcrypt so response timing doesn't reveal valid usernames
+	passwordErr := bcrypt.CompareHashAndPassword([]byte(config.PasswordHash), []byte(req.Password))
+	usernameMatch := subtle.ConstantTimeCompare([]byte(req.Username), []byte(config.Username)) == 1
+	if passwordErr != nil || !usernameMatch {
+		a.mu.Lock()
+		a.recordLoginFailureLocked(addr)
+		a.mu.Unlock()
+		log.Printf("Failed login attempt for user %q from %s", req.Username, addr)
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid username or password"})
+	}
+
+	a.mu.Lock()
+	delete(a.failures, addr)
+	a.mu.Unlock()
+
+	token, err := a.startSession(config.Username, []string{ScopeAdmin})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create session: " + err.Error()})
+	}
+	c.SetCookie(newSessionCookie(c, token, time.Now().Add(sessionTTL)))
+
+	return c.JSON(http.StatusOK, AuthStatusResponse{
+		Enabled:       true,
+		Authenticated: true,
+		Username:      config.Username,
+		Method:        "session",
+		Scopes:        []string{ScopeAdmin},
+	})
+}
+
+// Logout ends the current session
+// @Summary Log out
+// @Description Ends the current UI session
+// @Tags Auth
+// @Success 204
+// @Router /auth/logout [post]
+func (a *AuthService) Logout(c echo.Context) error {
+	if cookie, err := c.Cookie(sessionCookieName); err == nil {
+		a.mu.Lock()
+		delete(a.sessions, cookie.Value)
+		a.mu.Unlock()
+	}
+
+	c.SetCookie(newSessionCookie(c, "", time.Unix(0, 0)))
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetStatus returns the authentication state of the current request
+// @Summary Get auth status
+// @Description Returns whether authentication is enabled and who the caller is
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} AuthStatusResponse
+// @Router /auth/me [get]
+func (a *AuthService) GetStatus(c echo.Context) error {
+	if !a.Enabled() {
+		return c.JSON(http.StatusOK, AuthStatusResponse{Enabled: false, Authenticated: true})
+	}
+
+	a.mu.RLock()
+	localLogin := a.config != nil
+	a.mu.RUnlock()
+
+	status := AuthStatusResponse{
+		Enabled:    true,
+		LocalLogin: localLogin,
+		OIDC:       a.oidc != nil,
+	}
+
+	if principal := a.authenticate(c); principal != nil {
+		status.Authenticated = true
+		status.Username = principal.Name
+		status.Method = principal.Method
+		status.Scopes = principal.Scopes
+	}
+
+	return c.JSON(http.StatusOK, status)
+}
+
+// ChangePassword changes the admin password and ends all other sessions
+// @Summary Change password
+// @Description Changes the admin password. All existing sessions are ended.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param password body ChangePasswordRequest true "Current and new password"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /auth/password [put]
+func (a *AuthService) ChangePassword(c echo.Context) error {
+	var req ChangePasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format: " + err.Error()})
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Validation failed: " + err.Error()})
+	}
+
+	a.mu.RLock()
+	config := a.config
+	a.mu.RUnlock()
+
+	if config == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "no admin user is configured"})
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(config.PasswordHash), []byte(req.CurrentPassword)); err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "current password is incorrect"})
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to hash password: " + err.Error()})
+	}
+
+	updated := &AuthConfig{Username: config.Username, PasswordHash: string(hash)}
+	if err := a.storage.SaveAuthConfig(updated); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to persist password: " + err.Error()})
+	}
+
+	a.mu.Lock()
+	a.config = updated
+	a.sessions = make(map[string]*session)
+	a.mu.Unlock()
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Password updated, please log in again"})
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// backupArchiveVersion is the format version of backup archives written by this build
+const backupArchiveVersion = 1
+
+// BackupService handles backup and restore of the monitoring state
+type BackupService struct {
+	monitor       *MonitorService
+	notifications *NotificationService
+	storage       Store
+}
+
+// NewBackupService creates a new backup service
+func NewBackupService(monitor *MonitorService, notifications *NotificationService, storage Store) *BackupService {
+	return &BackupService{
+		monitor:       monitor,
+		notifications: notifications,
+		storage:       storage,
+	}
+}
+
+// Backup returns a versioned archive of services, incidents, notification settings and maintenance windows
+// @Summary Download a backup
+// @Description Returns a versioned archive of the monitoring state for POST /restore. Admin credentials and API keys are not included.
+// @Tags Backup
+// @Produce json
+// @Param redactSecrets query bool false "Leave notification secrets out of the archive"
+// @Param history query bool false "Include check history and rollups"
+// @Success 200 {object} BackupArchive
+// @Failure 500 {object} map[string]string
+// @Router /backup [get]
+func (b *BackupService) Backup(c echo.Context) error {
+	archive := &BackupArchive{
+		Version:         backupArchiveVersion,
+		CreatedAt:       time.Now(),
+		SecretsRedacted: c.QueryParam("redactSecrets") == "true",
+		Services:        []*Service{},
+		Incidents:       []*Incident{},
+	}
+
+	b.monitor.mu.RLock()
+	for _, service := range b.monitor.services {
+		snapshot := *service
+		archive.Services = append(archive.Services, &snapshot)
+	}
+	for _, incident := range b.monitor.incidents {
+		snapshot := *incident
+		archive.Incidents = append(archive.Incidents, &snapshot)
+	}
+	b.monitor.mu.RUnlock()
+	sort.Slice(archive.Services, func(i, j int) bool { return archive.Services[i].Name < archive.Services[j].Name })
+
+	config := *b.notifications.GetConfig()
+	if archive.SecretsRedacted {
+		config.UserKey = ""
+		config.AppToken = ""
+		config.WebhookURL = ""
+	}
+	archive.NotificationConfig = &config
+
+	windows, err := b.storage.LoadMaintenanceWindows()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load maintenance windows: " + err.Error()})
+	}
+	archive.MaintenanceWindows = windows
+
+	if c.QueryParam("history") == "true" {
+		history, err := b.storage.LoadCheckHistory()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load check history: " + err.Error()})
+		}
+		archive.History = history
+	}
+
+	filename := fmt.Sprintf("gjallarhorn-backup-%s.json", archive.CreatedAt.Format("20060102-150405"))
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	return c.JSON(http.StatusOK, archive)
+}
+
+// Restore validates a backup archive and replaces the monitoring state with it
+// @Summary Restore a backup
+// @Description Replaces services, incidents, notification settings and maintenance windows with the contents of a backup archive in a single all-or-nothing operation. Check history is only replaced when the archive includes it. Redacted notification secrets keep their current values.
+// @Tags Backup
+// @Accept json
+// @Produce json
+// @Param archive body BackupArchive true "Backup archive"
+// @Success 200 {object} RestoreResponse
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /restore [post]
+func (b *BackupService) Restore(c echo.Context) error {
+	var archive BackupArchive
+	if err := c.Bind(&archive); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format: " + err.Error()})
+	}
+
+	if err := validateBackupArchive(c, &archive); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid backup archive: " + err.Error()})
+	}
+
+	if archive.NotificationConfig == nil {
+		archive.NotificationConfig = &NotificationConfig{}
+	}
+	if archive.SecretsRedacted {
+		current := b.notifications.GetConfig()
+		if archive.NotificationConfig.UserKey == "" {
+			archive.NotificationConfig.UserKey = current.UserKey
+		}
+		if archive.NotificationConfig.AppToken == "" {
+			archive.NotificationConfig.AppToken = current.AppToken
+		}
+		if archive.NotificationConfig.WebhookURL == "" {
+			archive.NotificationConfig.WebhookURL = current.WebhookURL
+		}
+	}
+
+	services := make(map[string]*Service, len(archive.Services))
+	for _, service := range archive.Services {
+		services[service.ID] = service
+	}
+
+	// Apply atomically - hold lock through the restore to prevent races with checkService
+	m := b.monitor
+	m.mu.Lock()
+	if err := b.storage.Restore(&archive); err != nil {
+		m.mu.Unlock()
+		log.Printf("Error: Failed to restore backup: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to restore backup: " + err.Error(),
+		})
+	}
+
+	var removedIDs []string
+	for id := range m.services {
+		if _, exists := services[id]; !exists {
+			removedIDs = append(removedIDs, id)
+		}
+	}
+	previous := m.services
+	m.services = services
+	m.incidents = archive.Incidents
+	b.notifications.setConfig(archive.NotificationConfig)
+
+	for _, id := range removedIDs {
+		m.events.Publish(Event{Type: "service.deleted", ServiceID: id})
+	}
+	for id, service := range services {
+		if _, existed := previous[id]; existed {
+			relabelServiceMetrics(service)
+			m.publishServiceEvent("service.updated", service)
+		} else {
+			m.publishServiceEvent("service.created", service)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, id := range removedIDs {
+		deleteServiceMetrics(id)
+	}
+
+	response := RestoreResponse{
+		Success:            true,
+		Services:           len(archive.Services),
+		Incidents:          len(archive.Incidents),
+		MaintenanceWindows: len(archive.MaintenanceWindows),
+	}
+	if archive.History != nil {
+		response.CheckResults = len(archive.History.CheckResults)
+		response.Rollups = len(archive.History.Rollups)
+	}
+	log.Printf("Restored backup from %s with %d services", archive.CreatedAt.Format(time.RFC3339), len(archive.Services))
+	return c.JSON(http.StatusOK, response)
+}
+
+// validateBackupArchive checks an archive before anything is replaced, so a bad
+// archive leaves the current state untouched
+func validateBackupArchive(c echo.Context, archive *BackupArchive) error {
+	if archive.Version < 1 {
+		return fmt.Errorf("missing archive version")
+	}
+	if archive.Version > backupArchiveVersion {
+		return fmt.Errorf("archive version %d is newer than the supported version %d", archive.Version, backupArchiveVersion)
+	}
+
+	serviceIDs := make(map[string]bool, len(archive.Services))
+	for _, service := range archive.Services {
+		if service == nil || service.ID == "" {
+			return fmt.Errorf("service without an ID")
+		}
+		if serviceIDs[service.ID] {
+			return fmt.Errorf("duplicate service ID %s", service.ID)
+		}
+		serviceIDs[service.ID] = true
+
+		// Restored services must pass the same validation as newly created ones
+		req := CreateServiceRequest{Name: service.Name, URL: service.URL, Interval: service.Interval, Tags: service.Tags}
+		if err := c.Validate(&req); err != nil {
+			return fmt.Errorf("service %s: %v", service.ID, err)
+		}
+	}
+
+	incidentIDs := make(map[string]bool, len(archive.Incidents))
+	for _, incident := range archive.Incidents {
+		if incident == nil || incident.ID == "" {
+			return fmt.Errorf("incident without an ID")
+		}
+		if incidentIDs[incident.ID] {
+			return fmt.Errorf("duplicate incident ID %s", incident.ID)
+		}
+		incidentIDs[incident.ID] = true
+		if !serviceIDs[incident.ServiceID] {
+			return fmt.Errorf("incident %s refers to unknown service %s", incident.ID, incident.ServiceID)
+		}
+	}
+
+	windowIDs := make(map[string]bool, len(archive.MaintenanceWindows))
+	for _, window := range archive.MaintenanceWindows {
+		if window == nil || window.ID == "" {
+			return fmt.Errorf("maintenance window without an ID")
+		}
+		if windowIDs[window.ID] {
+			return fmt.Errorf("duplicate maintenance window ID %s", window.ID)
+		}
+		windowIDs[window.ID] = true
+		if window.ServiceID != "" && !serviceIDs[window.ServiceID] {
+			return fmt.Errorf("maintenance window %s refers to unknown service %s", window.ID, window.ServiceID)
+		}
+		if !window.EndsAt.After(window.StartsAt) {
+			return fmt.Errorf("maintenance window %s ends before it starts", window.ID)
+		}
+	}
+
+	if archive.History != nil {
+		for _, result := range archive.History.CheckResults {
+			if result == nil || !serviceIDs[result.ServiceID] {
+				return fmt.Errorf("check result refers to an unknown service")
+			}
+		}
+		for _, rollup := range archive.History.Rollups {
+			if rollup == nil || !serviceIDs[rollup.ServiceID] {
+				return fmt.Errorf("rollup refers to an unknown service")
+			}
+			if rollup.Period != rollupHour && rollup.Period != rollupDay {
+				return fmt.Errorf("rollup has unknown period '%s'", rollup.Period)
+			}
+		}
+	}
+
+	return nil
+}
+
+// LoadMaintenanceWindows implements Store; the JSON store keeps no maintenance windows
+func (s *FileStore) LoadMaintenanceWindows() ([]*MaintenanceWindow, error) {
+	return []*MaintenanceWindow{}, nil
+}
+
+// LoadCheckHistory implements Store; the JSON store keeps no check history
+func (s *FileStore) LoadCheckHistory() (*BackupHistory, error) {
+	return &BackupHistory{CheckResults: []*CheckResult{}, Rollups: []*CheckRollup{}}, nil
+}
+
+// Restore replaces services, incidents and the notification config. If writing
+// any file fails, the files already written are put back as they were.
+func (s *FileStore) Restore(archive *BackupArchive) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(archive.MaintenanceWindows) > 0 {
+		log.Printf("Warning: JSON storage keeps no maintenance windows, skipping %d from the backup", len(archive.MaintenanceWindows))
+	}
+	if archive.History != nil && len(archive.History.CheckResults)+len(archive.History.Rollups) > 0 {
+		log.Println("Warning: JSON storage keeps no check history, skipping history from the backup")
+	}
+
+	if err := s.ensureDataDir(); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	services := make(map[string]*Service, len(archive.Services))
+	for _, service := range archive.Services {
+		services[service.ID] = service
+	}
+	incidents := archive.Incidents
+	if incidents == nil {
+		incidents = []*Incident{}
+	}
+
+	files := []struct {
+		path  string
+		value interface{}
+	}{
+		{s.configFile, archive.NotificationConfig},
+		{s.incidentsFile, incidents},
+		{s.servicesFile, services},
+	}
+
+	contents := make([][]byte, len(files))
+	for i, file := range files {
+		data, err := json.MarshalIndent(file.value, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s: %v", file.path, err)
+		}
+		contents[i] = data
+	}
+
+	// Remember the current files so a failed restore can put them back
+	previous := make([][]byte, len(files))
+	for i, file := range files {
+		data, err := os.ReadFile(file.path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read %s: %v", file.path, err)
+		}
+		previous[i] = data
+	}
+
+	for i, file := range files {
+		if err := writeFileAtomic(file.path, contents[i]); err != nil {
+			for j := 0; j < i; j++ {
+				if previous[j] == nil {
+					os.Remove(files[j].path)
+				} else if rollbackErr := writeFileAtomic(files[j].path, previous[j]); rollbackErr != nil {
+					log.Printf("Error: Failed to roll back %s: %v", files[j].path, rollbackErr)
+				}
+			}
+			return fmt.Errorf("failed to write %s: %v", file.path, err)
+		}
+	}
+
+	// Drop the caches; they are reloaded on the next write
+	s.services = nil
+	s.incidents = nil
+	return nil
+}
+
+// LoadMaintenanceWindows loads all maintenance windows
+func (s *SQLStore) LoadMaintenanceWindows() ([]*MaintenanceWindow, error) {
+	rows, err := s.db.Query("SELECT id, service_id, starts_at, ends_at, reason FROM maintenance_windows ORDER BY starts_at")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query maintenance windows: %v", err)
+	}
+	defer rows.Close()
+
+	windows := []*MaintenanceWindow{}
+	for rows.Next() {
+		var window MaintenanceWindow
+		var serviceID sql.NullString
+		var startsAt, endsAt int64
+		if err := rows.Scan(&window.ID, &serviceID, &startsAt, &endsAt, &window.Reason); err != nil {
+			return nil, fmt.Errorf("failed to read maintenance window: %v", err)
+		}
+		window.ServiceID = serviceID.String
+		window.StartsAt = time.UnixMilli(startsAt)
+		window.EndsAt = time.UnixMilli(endsAt)
+		windows = append(windows, &window)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read maintenance windows: %v", err)
+	}
+
+	return windows, nil
+}
+
+// LoadCheckHistory loads all raw check results and rollups
+func (s *SQLStore) LoadCheckHistory() (*BackupHistory, error) {
+	history := &BackupHistory{CheckResults: []*CheckResult{}, Rollups: []*CheckRollup{}}
+
+	rows, err := s.db.Query("SELECT service_id, checked_at, status, response_time, error FROM check_results ORDER BY checked_at")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query check results: %v", err)
+	}
+	for rows.Next() {
+		var result CheckResult
+		var checkedAt int64
+		if err := rows.Scan(&result.ServiceID, &checkedAt, &result.Status, &result.ResponseTime, &result.Error); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read check result: %v", err)
+		}
+		result.Timestamp = time.UnixMilli(checkedAt)
+		history.CheckResults = append(history.CheckResults, &result)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read check results: %v", err)
+	}
+
+	rows, err = s.db.Query(`SELECT service_id, period, bucket_start, checks, successes, failures,
+		min_response_time, avg_response_time, max_response_time, p95_response_time FROM check_rollups ORDER BY bucket_start`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query check rollups: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rollup CheckRollup
+		var bucketStart int64
+		if err := rows.Scan(&rollup.ServiceID, &rollup.Period, &bucketStart, &rollup.Checks, &rollup.Successes, &rollup.Failures,
+			&rollup.MinResponseTime, &rollup.AvgResponseTime, &rollup.MaxResponseTime, &rollup.P95ResponseTime); err != nil {
+			return nil, fmt.Errorf("failed to read check rollup: %v", err)
+		}
+		rollup.BucketStart = time.UnixMilli(bucketStart)
+		history.Rollups = append(history.Rollups, &rollup)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read check rollups: %v", err)
+	}
+
+	return history, nil
+}
+
+// Restore replaces services, incidents, the notification config, maintenance windows
+// and, when included, check history in a single transaction. History of services that
+// are not in the archive is removed.
+func (s *SQLStore) Restore(archive *BackupArchive) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	tables := []string{"services", "incidents", "maintenance_windows", "notification_channels"}
+	if archive.History != nil {
+		tables = append(tables, "check_results", "check_rollups")
+	}
+	for _, table := range tables {
+		if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+			return fmt.Errorf("failed to clear %s: %v", table, err)
+		}
+	}
+
+	for _, service := range archive.Services {
+		data, err := json.Marshal(service)
+		if err != nil {
+			return fmt.Errorf("failed to marshal service: %v", err)
+		}
+		if _, err := tx.Exec(s.rebind("INSERT INTO services (id, data) VALUES (?, ?)"), service.ID, string(data)); err != nil {
+			return fmt.Errorf("failed to insert service: %v", err)
+		}
+	}
+
+	for _, incident := range archive.Incidents {
+		if _, err := tx.Exec(s.rebind("INSERT INTO incidents (id, service_id, started_at, resolved_at, error) VALUES (?, ?, ?, ?, ?)"),
+			incident.ID, incident.ServiceID, incident.StartedAt.UnixMilli(), nullUnixMillis(incident.ResolvedAt), incident.Error); err != nil {
+			return fmt.Errorf("failed to insert incident: %v", err)
+		}
+	}
+
+	config, err := json.Marshal(archive.NotificationConfig)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+	if _, err := tx.Exec(s.rebind("INSERT INTO notification_channels (name, config) VALUES (?, ?)"), "pushover", string(config)); err != nil {
+		return fmt.Errorf("failed to insert notification config: %v", err)
+	}
+
+	for _, window := range archive.MaintenanceWindows {
+		serviceID := sql.NullString{String: window.ServiceID, Valid: window.ServiceID != ""}
+		if _, err := tx.Exec(s.rebind("INSERT INTO maintenance_windows (id, service_id, starts_at, ends_at, reason) VALUES (?, ?, ?, ?, ?)"),
+			window.ID, serviceID, window.StartsAt.UnixMilli(), window.EndsAt.UnixMilli(), window.Reason); err != nil {
+			return fmt.Errorf("failed to insert maintenance window: %v", err)
+		}
+	}
+
+	if archive.History != nil {
+		for _, result := range archive.History.CheckResults {
+			if _, err := tx.Exec(s.rebind("INSERT INTO check_results (service_id, checked_at, status, response_time, error) VALUES (?, ?, ?, ?, ?)"),
+				result.ServiceID, result.Timestamp.UnixMilli(), result.Status, result.ResponseTime, result.Error); err != nil {
+				return fmt.Errorf("failed to insert check result: %v", err)
+			}
+		}
+		for _, rollup := range archive.History.Rollups {
+			if _, err := tx.Exec(s.rebind(`INSERT INTO check_rollups (service_id, period, bucket_start, checks, successes, failures,
+				min_response_time, avg_response_time, max_response_time, p95_response_time) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+				rollup.ServiceID, rollup.Period, rollup.BucketStart.UnixMilli(), rollup.Checks, rollup.Successes, rollup.Failures,
+				rollup.MinResponseTime, rollup.AvgResponseTime, rollup.MaxResponseTime, rollup.P95ResponseTime); err != nil {
+				return fmt.Errorf("failed to insert check rollup: %v", err)
+			}
+		}
+	}
+	if err := deleteRemovedServiceData(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit restore: %v", err)
+	}
+	return nil
+}
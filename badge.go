@@ -0,0 +1,414 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Badge colors, matching the shields.io palette
+const (
+	badgeColorBrightGreen = "#4c1"
+	badgeColorGreen       = "#97ca00"
+	badgeColorYellowGreen = "#a4a61d"
+	badgeColorYellow      = "#dfb317"
+	badgeColorOrange      = "#fe7d37"
+	badgeColorRed         = "#e05d44"
+	badgeColorGrey        = "#9f9f9f"
+	badgeLabelColor       = "#555"
+)
+
+// defaultUptimeWindow is used when no window is given for the uptime badge
+const defaultUptimeWindow = 30 * 24 * time.Hour
+
+// maxUptimeWindow caps the window accepted by the uptime badge
+const maxUptimeWindow = 365 * 24 * time.Hour
+
+const badgeTemplate = `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[2]s: %[3]s">
+  <title>%[2]s: %[3]s</title>
+  <linearGradient id="s" x2="0" y2="100%%">
+    <stop offset="0" stop-color="#bbb" stop-opacity=".1"/>
+    <stop offset="1" stop-opacity=".1"/>
+  </linearGradient>
+  <clipPath id="r">
+    <rect width="%[1]d" height="20" rx="3" fill="#fff"/>
+  </clipPath>
+  <g clip-path="url(#r)">
+    <rect width="%[4]d" height="20" fill="%[6]s"/>
+    <rect x="%[4]d" width="%[5]d" height="20" fill="%[7]s"/>
+    <rect width="%[1]d" height="20" fill="url(#s)"/>
+  </g>
+  <g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+    <text x="%[8]d" y="15" fill="#010101" fill-opacity=".3">%[2]s</text>
+    <text x="%[8]d" y="14">%[2]s</text>
+    <text x="%[9]d" y="15" fill="#010101" fill-opacity=".3">%[3]s</text>
+    <text x="%[9]d" y="14">%[3]s</text>
+  </g>
+</svg>
+`
+
+// renderBadge renders a flat shields-style SVG badge
+func renderBadge(label, message, color string) string {
+	labelWidth := badgeTextWidth(label) + 10
+	messageWidth := badgeTextWidth(message) + 10
+	label = html.EscapeString(label)
+	message = html.EscapeString(message)
+
+	return fmt.Sprintf(badgeTemplate,
+		labelWidth+messageWidth, label, message,
+		labelWidth, messageWidth, badgeLabelColor, color,
+		labelWidth/2, labelWidth+messageWidth/2)
+}
+
+// badgeTextWidth approximates the rendered width of text in 11px Verdana
+func badgeTextWidth(text string) int {
+	width := 0.0
+	for _, r := range text {
+		switch {
+		case strings.ContainsRune("ijlt.,:;!|'", r):
+			width += 3.5
+		case strings.ContainsRune("frI() ", r):
+			width += 4.5
+		case strings.ContainsRune("mwMW%", r):
+			width += 10
+		case r >= 'A' && r <= 'Z':
+			width += 7.5
+		default:
+			width += 6.5
+		}
+	}
+	return int(width + 0.5)
+}
+
+// sendBadge writes an SVG badge response that image proxies won't cache
+func sendBadge(c echo.Context, code int, label, message, color string) error {
+	c.Response().Header().Set("Cache-Control", "no-cache, no-store, must-revalidate, max-age=0")
+	return c.Blob(code, "image/svg+xml;charset=utf-8", []byte(renderBadge(label, message, color)))
+}
+
+// findBadgeServiceLocked resolves a badge key to a service, assuming the lock is held.
+// The key may be a badge token or a service ID; services with a badge token are
+// only reachable by that token so their IDs are never exposed publicly.
+func (m *MonitorService) findBadgeServiceLocked(key string) *Service {
+	if key == "" {
+		return nil
+	}
+	for _, service := range m.services {
+		if service.BadgeToken != "" && service.BadgeToken == key {
+			return service
+		}
+	}
+	if service, exists := m.services[key]; exists && service.BadgeToken == "" {
+		return service
+	}
+	return nil
+}
+
+// uptimeLocked calculates the uptime percentage of a service over a window, assuming the lock is held
+func (m *MonitorService) uptimeLocked(service *Service, window time.Duration, now time.Time) float64 {
+	start := now.Add(-window)
+	if service.CreatedAt.After(start) {
+		start = service.CreatedAt
+	}
+	total := now.Sub(start)
+	if total <= 0 {
+		return 100
+	}
+
+	var downtime time.Duration
+	for _, incident := range m.incidents {
+		if incident.ServiceID != service.ID {
+			continue
+		}
+		from := incident.StartedAt
+		to := now
+		if incident.ResolvedAt != nil {
+			to = *incident.ResolvedAt
+		}
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(now) {
+			to = now
+		}
+		if to.After(from) {
+			downtime += to.Sub(from)
+		}
+	}
+
+	uptime := 100 * (1 - float64(downtime)/float64(total))
+	if uptime < 0 {
+		return 0
+	}
+	return uptime
+}
+
+// parseUptimeWindow parses windows such as "30d", "7d" or "24h"
+func parseUptimeWindow(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultUptimeWindow, nil
+	}
+
+	var window time.Duration
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid window %q", value)
+		}
+		window = time.Duration(days) * 24 * time.Hour
+	} else {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid window %q", value)
+		}
+		window = parsed
+	}
+
+	if window < time.Hour || window > maxUptimeWindow {
+		return 0, fmt.Errorf("window must be between 1h and 365d")
+	}
+	return window, nil
+}
+
+// uptimeColor picks the badge color for an uptime percentage
+func uptimeColor(uptime float64) string {
+	switch {
+	case uptime >= 99.9:
+		return badgeColorBrightGreen
+	case uptime >= 99:
+		return badgeColorGreen
+	case uptime >= 97:
+		return badgeColorYellowGreen
+	case uptime >= 95:
+		return badgeColorYellow
+	case uptime >= 90:
+		return badgeColorOrange
+	default:
+		return badgeColorRed
+	}
+}
+
+// latencyColor picks the badge color for a response time in milliseconds
+func latencyColor(responseTime int64) string {
+	switch {
+	case responseTime < 200:
+		return badgeColorBrightGreen
+	case responseTime < 500:
+		return badgeColorGreen
+	case responseTime < 1000:
+		return badgeColorYellow
+	case responseTime < 2000:
+		return badgeColorOrange
+	default:
+		return badgeColorRed
+	}
+}
+
+// formatUptime formats an uptime percentage without misleading rounding up to 100%
+func formatUptime(uptime float64) string {
+	if uptime >= 100 {
+		return "100%"
+	}
+	formatted := strconv.FormatFloat(float64(int(uptime*100))/100, 'f', 2, 64)
+	return formatted + "%"
+}
+
+// GetStatusBadge renders the current status of a service as an SVG badge
+// @Summary Get status badge
+// @Description Returns an SVG badge showing the current status of a service
+// @Tags Badges
+// @Produce image/svg+xml
+// @Param id path string true "Service ID or badge token"
+// @Param label query string false "Badge label"
+// @Success 200 {string} string "SVG badge"
+// @Failure 404 {string} string "SVG badge"
+// @Router /badge/{id}/status.svg [get]
+func (m *MonitorService) GetStatusBadge(c echo.Context) error {
+	label := c.QueryParam("label")
+	if label == "" {
+		label = "status"
+	}
+
+	m.mu.RLock()
+	service := m.findBadgeServiceLocked(c.Param("id"))
+	if service == nil {
+		m.mu.RUnlock()
+		return sendBadge(c, http.StatusNotFound, label, "not found", badgeColorGrey)
+	}
+	status := service.Status
+	m.mu.RUnlock()
+
+	switch status {
+	case "online":
+		return sendBadge(c, http.StatusOK, label, "up", badgeColorBrightGreen)
+	case "offline":
+		return sendBadge(c, http.StatusOK, label, "down", badgeColorRed)
+	default:
+		return sendBadge(c, http.StatusOK, label, "unknown", badgeColorGrey)
+	}
+}
+
+// GetUptimeBadge renders the uptime of a service over a window as an SVG badge
+// @Summary Get uptime badge
+// @Description Returns an SVG badge showing the uptime percentage of a service over a window
+// @Tags Badges
+// @Produce image/svg+xml
+// @Param id path string true "Service ID or badge token"
+// @Param window query string false "Window such as 24h, 7d or 30d" default(30d)
+// @Param label query string false "Badge label"
+// @Success 200 {string} string "SVG badge"
+// @Failure 400 {string} string "SVG badge"
+// @Failure 404 {string} string "SVG badge"
+// @Router /badge/{id}/uptime.svg [get]
+func (m *MonitorService) GetUptimeBadge(c echo.Context) error {
+	windowParam := c.QueryParam("window")
+	label := c.QueryParam("label")
+	if label == "" {
+		label = "uptime"
+		if windowParam != "" {
+			label += " " + windowParam
+		}
+	}
+
+	window, err := parseUptimeWindow(windowParam)
+	if err != nil {
+		return sendBadge(c, http.StatusBadRequest, label, err.Error(), badgeColorGrey)
+	}
+
+	m.mu.RLock()
+	service := m.findBadgeServiceLocked(c.Param("id"))
+	if service == nil {
+		m.mu.RUnlock()
+		return sendBadge(c, http.StatusNotFound, label, "not found", badgeColorGrey)
+	}
+	if service.LastChecked.IsZero() {
+		m.mu.RUnlock()
+		return sendBadge(c, http.StatusOK, label, "unknown", badgeColorGrey)
+	}
+	uptime := m.uptimeLocked(service, window, time.Now())
+	m.mu.RUnlock()
+
+	return sendBadge(c, http.StatusOK, label, formatUptime(uptime), uptimeColor(uptime))
+}
+
+// GetLatencyBadge renders the last response time of a service as an SVG badge
+// @Summary Get latency badge
+// @Description Returns an SVG badge showing the last measured response time of a service
+// @Tags Badges
+// @Produce image/svg+xml
+// @Param id path string true "Service ID or badge token"
+// @Param label query string false "Badge label"
+// @Success 200 {string} string "SVG badge"
+// @Failure 404 {string} string "SVG badge"
+// @Router /badge/{id}/latency.svg [get]
+func (m *MonitorService) GetLatencyBadge(c echo.Context) error {
+	label := c.QueryParam("label")
+	if label == "" {
+		label = "latency"
+	}
+
+	m.mu.RLock()
+	service := m.findBadgeServiceLocked(c.Param("id"))
+	if service == nil {
+		m.mu.RUnlock()
+		return sendBadge(c, http.StatusNotFound, label, "not found", badgeColorGrey)
+	}
+	checked := !service.LastChecked.IsZero()
+	responseTime := service.LastResponseTime
+	m.mu.RUnlock()
+
+	if !checked {
+		return sendBadge(c, http.StatusOK, label, "unknown", badgeColorGrey)
+	}
+	return sendBadge(c, http.StatusOK, label, fmt.Sprintf("%dms", responseTime), latencyColor(responseTime))
+}
+
+// generateToken returns a random hex-encoded token of the given byte length
+func generateToken(length int) (string, error) {
+	buf := make([]byte, length)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// CreateBadgeToken generates a public badge token for a service
+// @Summary Create badge token
+// @Description Generates (or rotates) a public badge token so badges can be embedded without exposing the service ID
+// @Tags Badges
+// @Produce json
+// @Param id path string true "Service ID"
+// @Success 200 {object} BadgeTokenResponse
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /services/{id}/badge-token [post]
+func (m *MonitorService) CreateBadgeToken(c echo.Context) error {
+	id := c.Param("id")
+	if id == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "service ID is required"})
+	}
+
+	token, err := generateToken(16)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate token: " + err.Error()})
+	}
+
+	m.mu.Lock()
+	service, exists := m.services[id]
+	if !exists {
+		m.mu.Unlock()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "service not found"})
+	}
+	service.BadgeToken = token
+	service.UpdatedAt = time.Now()
+	m.publishServiceEvent("service.updated", service)
+
+	// Save to persistent storage
+	if err := m.storage.SaveService(service); err != nil {
+		log.Printf("Warning: Failed to save service to storage: %v", err)
+	}
+	m.mu.Unlock()
+
+	return c.JSON(http.StatusOK, BadgeTokenResponse{Token: token})
+}
+
+// DeleteBadgeToken revokes the public badge token of a service
+// @Summary Delete badge token
+// @Description Revokes the badge token of a service; badges are then served by service ID again
+// @Tags Badges
+// @Param id path string true "Service ID"
+// @Success 204
+// @Failure 404 {object} map[string]string
+// @Router /services/{id}/badge-token [delete]
+func (m *MonitorService) DeleteBadgeToken(c echo.Context) error {
+	id := c.Param("id")
+	if id == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "service ID is required"})
+	}
+
+	m.mu.Lock()
+	service, exists := m.services[id]
+	if !exists {
+		m.mu.Unlock()
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "service not found"})
+	}
+	service.BadgeToken = ""
+	service.UpdatedAt = time.Now()
+	m.publishServiceEvent("service.updated", service)
+
+	// Save to persistent storage
+	if err := m.storage.SaveService(service); err != nil {
+		log.Printf("Warning: Failed to save service to storage: %v", err)
+	}
+	m.mu.Unlock()
+
+	return c.NoContent(http.StatusNoContent)
+}
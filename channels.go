@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// channelFailureThreshold is the number of consecutive failed attempts after which a
+// channel is unhealthy and notifications fall back to the next configured channel
+const channelFailureThreshold = 3
+
+// attempt sends a notification through a channel with the given settings, records the attempt in the delivery
+// log and updates the health of the channel. It returns the attempt and the body of the
+// provider's response. Attempts interrupted by shutdown are not recorded.
+func (n *NotificationService) attempt(ctx context.Context, config *NotificationConfig, channel string, notification *NotificationDelivery, fallback bool) (*NotificationAttempt, []byte, error) {
+	start := time.Now()
+	statusCode, body, err := sendTo(ctx, config, channel, notification)
+	if ctx.Err() != nil {
+		return nil, body, err
+	}
+	recordNotification(channel, notification.Event, err)
+
+	attempt := &NotificationAttempt{
+		ID:          uuid.New().String(),
+		DeliveryID:  notification.ID,
+		Channel:     channel,
+		Event:       notification.Event,
+		ServiceID:   notification.ServiceID,
+		StatusCode:  statusCode,
+		Latency:     time.Since(start).Milliseconds(),
+		Fallback:    fallback,
+		AttemptedAt: start,
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	if err := n.storage.SaveNotificationAttempt(attempt); err != nil {
+		log.Printf("Warning: Failed to save notification attempt: %v", err)
+	}
+
+	n.healthMu.Lock()
+	status := n.updateChannelHealthLocked(attempt)
+	n.healthMu.Unlock()
+
+	switch {
+	case err != nil && status.ConsecutiveFailures == channelFailureThreshold:
+		log.Printf("Error: Notification channel %s is unhealthy after %d consecutive failures: %v", channel, channelFailureThreshold, err)
+		n.SendChannelAlert(channel, err)
+	case err != nil:
+		log.Printf("Warning: Failed to send %s notification through %s: %v", notification.Event, channel, err)
+	case status.recovered:
+		log.Printf("Notification channel %s is delivering again", channel)
+	}
+	return attempt, body, err
+}
+
+// channelHealth tracks a channel's recent attempts
+type channelHealth struct {
+	NotificationChannelStatus
+	recovered bool // The last attempt succeeded after the channel was unhealthy
+}
+
+// updateChannelHealthLocked applies an attempt to the health of its channel, assuming healthMu is held
+func (n *NotificationService) updateChannelHealthLocked(attempt *NotificationAttempt) channelHealth {
+	health, ok := n.health[attempt.Channel]
+	if !ok {
+		health = &channelHealth{NotificationChannelStatus: NotificationChannelStatus{Channel: attempt.Channel, Healthy: true}}
+		n.health[attempt.Channel] = health
+	}
+
+	attemptedAt := attempt.AttemptedAt
+	health.LastAttemptAt = &attemptedAt
+	health.recovered = false
+	if attempt.Error == "" {
+		health.recovered = !health.Healthy
+		health.Healthy = true
+		health.ConsecutiveFailures = 0
+		health.LastError = ""
+		health.LastSuccessAt = &attemptedAt
+	} else {
+		health.ConsecutiveFailures++
+		health.LastError = attempt.Error
+		health.Healthy = health.ConsecutiveFailures < channelFailureThreshold
+	}
+	return *health
+}
+
+// channelUnhealthy reports whether a channel has failed repeatedly
+func (n *NotificationService) channelUnhealthy(channel string) bool {
+	n.healthMu.Lock()
+	defer n.healthMu.Unlock()
+	health, ok := n.health[channel]
+	return ok && !health.Healthy
+}
+
+// deliverThroughChannels sends a notification through the first configured channel. When
+// that channel rejects it, such as for a revoked token, or has failed repeatedly, the next
+// one is tried as well. Broadcasts only go through their own channel. The error is
+// permanent only when every channel tried failed permanently.
+func (n *NotificationService) deliverThroughChannels(delivery *NotificationDelivery) error {
+	config := n.currentConfig()
+	if !config.Enabled {
+		return permanentError{fmt.Errorf("notifications were disabled before the notification was sent")}
+	}
+	channels := config.channels()
+	if len(channels) == 0 {
+		return permanentError{fmt.Errorf("no notification channel is configured")}
+	}
+	if delivery.Broadcast {
+		if !config.configured(delivery.Channel) {
+			return permanentError{fmt.Errorf("channel %s is no longer configured", delivery.Channel)}
+		}
+		channels = []string{delivery.Channel}
+	}
+
+	var failures []error
+	var messages []string
+	for i, channel := range channels {
+		delivery.Channel = channel
+		_, _, err := n.attempt(n.workersCtx, config, channel, delivery, i > 0)
+		if err == nil || n.workersCtx.Err() != nil {
+			return err
+		}
+		failures = append(failures, err)
+		messages = append(messages, fmt.Sprintf("%s: %v", channel, err))
+		// Retrying a channel that failed temporarily is left to the next attempt
+		var permanent permanentError
+		if !errors.As(err, &permanent) && !n.channelUnhealthy(channel) {
+			break
+		}
+	}
+
+	if len(failures) == 1 {
+		return failures[0]
+	}
+	err := errors.New(strings.Join(messages, "; "))
+	for _, failure := range failures {
+		var permanent permanentError
+		if !errors.As(failure, &permanent) {
+			return err
+		}
+	}
+	return permanentError{err}
+}
+
+// SendChannelAlert queues a notification that a channel keeps failing. It is only sent
+// when another channel is configured to deliver it.
+func (n *NotificationService) SendChannelAlert(channel string, err error) {
+	if config := n.currentConfig(); !config.Enabled || len(config.channels()) < 2 {
+		return
+	}
+
+	n.enqueue(&NotificationDelivery{
+		Event: "channel_unhealthy",
+		Title: fmt.Sprintf("⚠️ Notification Channel Failing: %s", channel),
+		Message: fmt.Sprintf("The last %d notifications through %s failed and are being sent through the next channel instead.\nLast error: %v",
+			channelFailureThreshold, channel, err),
+		Sound:    "pushover",
+		Priority: "1",
+	})
+}
+
+// loadChannelHealth rebuilds the health of the channels from the stored attempts
+func (n *NotificationService) loadChannelHealth() {
+	attempts, err := n.storage.LoadNotificationAttempts()
+	if err != nil {
+		log.Printf("Warning: Failed to load notification attempts from storage: %v", err)
+		return
+	}
+
+	n.healthMu.Lock()
+	defer n.healthMu.Unlock()
+	for _, attempt := range attempts {
+		n.updateChannelHealthLocked(attempt)
+	}
+	for channel, health := range n.health {
+		if !health.Healthy {
+			log.Printf("Warning: Notification channel %s is unhealthy: %s", channel, health.LastError)
+		}
+	}
+}
+
+// SendTestNotification sends a clearly marked test notification through each of the given
+// channels right away, even when notifications are disabled, and returns how each went
+func (n *NotificationService) SendTestNotification(ctx context.Context, channels []string) []NotificationTestResult {
+	config := n.currentConfig()
+	results := make([]NotificationTestResult, 0, len(channels))
+	for _, channel := range channels {
+		result := NotificationTestResult{Channel: channel}
+		attempt, body, err := n.attempt(ctx, config, channel, &NotificationDelivery{
+			Event: "test",
+			Title: "🔔 Gjallarhorn Test Notification",
+			Message: fmt.Sprintf("This is a test notification sent through %s. No service is affected.\nSent: %s",
+				channel, time.Now().Format(time.RFC3339)),
+			Priority: "0",
+		}, false)
+		if attempt != nil {
+			result.StatusCode = attempt.StatusCode
+			result.Latency = attempt.Latency
+		}
+		result.Response = string(body)
+		result.Success = err == nil
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// TestNotification sends a test notification through the configured channels
+// @Summary Send a test notification
+// @Description Sends a clearly marked test notification right away through the given channel, or through every configured channel, and returns each provider's response or error. Tests are sent even when notifications are disabled and are recorded in the delivery log; a successful test marks an unhealthy channel healthy again.
+// @Tags Notifications
+// @Produce json
+// @Param channel query string false "Channel to test; every configured channel when omitted" Enums(pushover, webhook)
+// @Success 200 {object} NotificationTestResponse
+// @Failure 400 {object} map[string]string
+// @Failure 502 {object} NotificationTestResponse
+// @Router /notifications/test [post]
+func (n *NotificationService) TestNotification(c echo.Context) error {
+	config := n.currentConfig()
+	channels := config.channels()
+	if channel := c.QueryParam("channel"); channel != "" {
+		if channel != channelPushover && channel != channelWebhook {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "channel must be pushover or webhook"})
+		}
+		if !config.configured(channel) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("channel %s is not configured", channel)})
+		}
+		channels = []string{channel}
+	}
+	if len(channels) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "no notification channel is configured"})
+	}
+
+	response := NotificationTestResponse{Success: true, Results: n.SendTestNotification(c.Request().Context(), channels)}
+	for _, result := range response.Results {
+		if !result.Success {
+			response.Success = false
+		}
+	}
+	if !response.Success {
+		return c.JSON(http.StatusBadGateway, response)
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// ListChannels returns the health of the notification channels
+// @Summary List notification channels
+// @Description Returns each notification channel with whether it is configured and healthy. A channel is unhealthy after 3 consecutive failed attempts; notifications then fall back to the next configured channel until it delivers again.
+// @Tags Notifications
+// @Produce json
+// @Success 200 {array} NotificationChannelStatus
+// @Router /notifications/channels [get]
+func (n *NotificationService) ListChannels(c echo.Context) error {
+	config := n.currentConfig()
+	n.healthMu.Lock()
+	defer n.healthMu.Unlock()
+
+	channels := make([]NotificationChannelStatus, 0, len(notificationChannels))
+	for _, channel := range notificationChannels {
+		status := NotificationChannelStatus{Channel: channel, Healthy: true}
+		if health, ok := n.health[channel]; ok {
+			status = health.NotificationChannelStatus
+		}
+		status.Configured = config.configured(channel)
+		channels = append(channels, status)
+	}
+	return c.JSON(http.StatusOK, channels)
+}
+
+// ListAttempts returns the notification delivery log
+// @Summary List notification attempts
+// @Description Returns the attempts to send notifications in the last 7 days, newest first, with the channel, HTTP status of the provider's response, error and latency of each.
+// @Tags Notifications
+// @Produce json
+// @Param channel query string false "Only attempts through this channel" Enums(pushover, webhook)
+// @Param failed query bool false "Only failed attempts"
+// @Param limit query int false "Maximum number of attempts" default(100)
+// @Success 200 {array} NotificationAttempt
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /notifications/attempts [get]
+func (n *NotificationService) ListAttempts(c echo.Context) error {
+	channel := c.QueryParam("channel")
+	if channel != "" && channel != channelPushover && channel != channelWebhook {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "channel must be pushover or webhook"})
+	}
+	failed := c.QueryParam("failed") == "true"
+
+	limit := 100
+	if limitStr := c.QueryParam("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and 1000"})
+		}
+		limit = parsed
+	}
+
+	attempts, err := n.storage.LoadNotificationAttempts()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load notification attempts: " + err.Error()})
+	}
+
+	result := make([]*NotificationAttempt, 0, len(attempts))
+	for _, attempt := range attempts {
+		if (channel == "" || attempt.Channel == channel) && (!failed || attempt.Error != "") {
+			result = append(result, attempt)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].AttemptedAt.After(result[j].AttemptedAt) })
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// LoadNotificationAttempts loads all stored notification attempts, oldest first
+func (s *FileStore) LoadNotificationAttempts() ([]*NotificationAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.attempts == nil {
+		attempts, err := s.readAttemptsFile()
+		if err != nil {
+			return nil, err
+		}
+		s.attempts = attempts
+	}
+
+	attempts := make([]*NotificationAttempt, 0, len(s.attempts))
+	for _, attempt := range s.attempts {
+		snapshot := *attempt
+		attempts = append(attempts, &snapshot)
+	}
+	return attempts, nil
+}
+
+// SaveNotificationAttempt appends an attempt to the delivery log
+func (s *FileStore) SaveNotificationAttempt(attempt *NotificationAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.attempts == nil {
+		attempts, err := s.readAttemptsFile()
+		if err != nil {
+			return err
+		}
+		s.attempts = attempts
+	}
+
+	snapshot := *attempt
+	s.attempts = append(s.attempts, &snapshot)
+	return s.writeAttemptsLocked()
+}
+
+// PruneNotificationAttempts removes attempts made before a time
+func (s *FileStore) PruneNotificationAttempts(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.attempts == nil {
+		attempts, err := s.readAttemptsFile()
+		if err != nil {
+			return err
+		}
+		s.attempts = attempts
+	}
+
+	kept := make([]*NotificationAttempt, 0, len(s.attempts))
+	for _, attempt := range s.attempts {
+		if !attempt.AttemptedAt.Before(before) {
+			kept = append(kept, attempt)
+		}
+	}
+	if len(kept) == len(s.attempts) {
+		return nil
+	}
+	s.attempts = kept
+	return s.writeAttemptsLocked()
+}
+
+// writeAttemptsLocked writes the cached attempts to the attempts file, assuming the lock is held
+func (s *FileStore) writeAttemptsLocked() error {
+	if err := s.ensureDataDir(); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(s.attempts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification attempts: %v", err)
+	}
+
+	if err := writeFileAtomic(s.attemptsFile, data); err != nil {
+		return fmt.Errorf("failed to write notification attempts file: %v", err)
+	}
+
+	return nil
+}
+
+// readAttemptsFile reads the attempts file
+func (s *FileStore) readAttemptsFile() ([]*NotificationAttempt, error) {
+	if _, err := os.Stat(s.attemptsFile); os.IsNotExist(err) {
+		return []*NotificationAttempt{}, nil
+	}
+
+	data, err := readFileWithFallback(s.attemptsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notification attempts file: %v", err)
+	}
+
+	var attempts []*NotificationAttempt
+	if err := json.Unmarshal(data, &attempts); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal notification attempts: %v", err)
+	}
+
+	if attempts == nil {
+		attempts = []*NotificationAttempt{}
+	}
+
+	return attempts, nil
+}
+
+// LoadNotificationAttempts loads all stored notification attempts, oldest first
+func (s *SQLStore) LoadNotificationAttempts() ([]*NotificationAttempt, error) {
+	rows, err := s.db.Query("SELECT data FROM notification_attempts ORDER BY attempted_at")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notification attempts: %v", err)
+	}
+	defer rows.Close()
+
+	attempts := []*NotificationAttempt{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to read notification attempt: %v", err)
+		}
+		var attempt NotificationAttempt
+		if err := json.Unmarshal([]byte(data), &attempt); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal notification attempt: %v", err)
+		}
+		attempts = append(attempts, &attempt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read notification attempts: %v", err)
+	}
+
+	return attempts, nil
+}
+
+// SaveNotificationAttempt appends an attempt to the delivery log
+func (s *SQLStore) SaveNotificationAttempt(attempt *NotificationAttempt) error {
+	data, err := json.Marshal(attempt)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification attempt: %v", err)
+	}
+
+	if _, err := s.exec("INSERT INTO notification_attempts (id, channel, attempted_at, data) VALUES (?, ?, ?, ?)",
+		attempt.ID, attempt.Channel, attempt.AttemptedAt.UnixMilli(), string(data)); err != nil {
+		return fmt.Errorf("failed to save notification attempt: %v", err)
+	}
+	return nil
+}
+
+// PruneNotificationAttempts removes attempts made before a time
+func (s *SQLStore) PruneNotificationAttempts(before time.Time) error {
+	if _, err := s.exec("DELETE FROM notification_attempts WHERE attempted_at < ?", before.UnixMilli()); err != nil {
+		return fmt.Errorf("failed to prune notification attempts: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestNotificationService creates a notification service that sends Pushover
+// notifications to pushover and webhooks to webhook
+func newTestNotificationService(t *testing.T, pushover, webhook *httptest.Server) *NotificationService {
+	t.Helper()
+	previous := pushoverAPIURL
+	pushoverAPIURL = pushover.URL
+	t.Cleanup(func() { pushoverAPIURL = previous })
+
+	store := NewFileStore(t.TempDir())
+	if err := store.SaveNotificationConfig(&NotificationConfig{UserKey: "user", AppToken: "token", WebhookURL: webhook.URL, Enabled: true}); err != nil {
+		t.Fatalf("SaveNotificationConfig failed: %v", err)
+	}
+	return NewNotificationService(store)
+}
+
+// deliverDue delivers every queued notification that is due, as the workers would
+func deliverDue(n *NotificationService) {
+	for {
+		delivery, _ := n.nextDelivery(time.Now())
+		if delivery == nil {
+			return
+		}
+		n.deliver(delivery)
+	}
+}
+
+func TestDeliveryFallsBackToWebhook(t *testing.T) {
+	tests := []struct {
+		name           string
+		pushoverStatus int
+		pushoverBody   string
+		wantStatus     string
+		wantWebhook    bool
+	}{
+		{"revoked token", http.StatusBadRequest, `{"token":"invalid","errors":["application token is invalid"],"status":0}`, deliveryDelivered, true},
+		{"temporary outage", http.StatusInternalServerError, "", deliveryPending, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pushover := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.pushoverStatus)
+				w.Write([]byte(tt.pushoverBody))
+			}))
+			defer pushover.Close()
+			var webhookCalls atomic.Int32
+			webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				webhookCalls.Add(1)
+			}))
+			defer webhook.Close()
+
+			n := newTestNotificationService(t, pushover, webhook)
+			service := &Service{ID: "web", Name: "Web", URL: "https://example.com", LastChecked: time.Now()}
+
+			// The first notifications, before Pushover has failed often enough to count as unhealthy
+			for i := 0; i < channelFailureThreshold; i++ {
+				n.SendNotification(service, "timeout")
+				deliverDue(n)
+
+				deliveries, err := n.storage.LoadNotificationDeliveries()
+				if err != nil {
+					t.Fatalf("LoadNotificationDeliveries failed: %v", err)
+				}
+				if got := deliveries[i].Status; got != tt.wantStatus {
+					t.Fatalf("notification %d status = %s (%s), want %s", i+1, got, deliveries[i].LastError, tt.wantStatus)
+				}
+				if tt.wantStatus == deliveryPending {
+					break
+				}
+			}
+
+			if gotWebhook := webhookCalls.Load() > 0; gotWebhook != tt.wantWebhook {
+				t.Errorf("webhook called %d times, want calls: %v", webhookCalls.Load(), tt.wantWebhook)
+			}
+			if !tt.wantWebhook {
+				return
+			}
+
+			attempts, err := n.storage.LoadNotificationAttempts()
+			if err != nil {
+				t.Fatalf("LoadNotificationAttempts failed: %v", err)
+			}
+			if len(attempts) < 2 || attempts[0].Channel != channelPushover || attempts[0].StatusCode != http.StatusBadRequest ||
+				attempts[1].Channel != channelWebhook || !attempts[1].Fallback || attempts[1].Error != "" {
+				t.Errorf("first attempts = %+v, %+v; want a rejected Pushover attempt, then a webhook fallback", attempts[0], attempts[1])
+			}
+		})
+	}
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// cliUsage lists the subcommands
+const cliUsage = `Usage: gjallarhorn <command> [flags]
+
+Commands:
+  serve                  Run the monitoring server (the default without a command)
+  check <url>            Check a URL once, the way the server does
+  services list          List the services of a running instance
+  services add <url>     Add a service to a running instance
+  services rm <id|name>  Delete services from a running instance
+  config validate        Validate the config file
+  notify test            Send a test notification from a running instance through each configured channel
+
+Run 'gjallarhorn <command> -h' for the flags of a command.
+`
+
+// errUsage is returned by commands called with invalid arguments, after printing their usage
+var errUsage = fmt.Errorf("invalid usage")
+
+// runCLI runs the command given on the command line and returns the exit code
+func runCLI(args []string) int {
+	// Without a command, flags are for the server, as before commands existed
+	if len(args) == 0 || (strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "-help" && args[0] != "--help") {
+		args = append([]string{"serve"}, args...)
+	}
+
+	var err error
+	switch args[0] {
+	case "serve":
+		err = serve(args[1:])
+	case "check":
+		err = runCheckCommand(args[1:])
+	case "services":
+		err = runServicesCommand(args[1:])
+	case "config":
+		err = runConfigCommand(args[1:])
+	case "notify":
+		err = runNotifyCommand(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(cliUsage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command '%s'\n\n%s", args[0], cliUsage)
+		return 2
+	}
+
+	if err == errUsage {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// addStorageFlags adds the flags that locate the data directory and config file
+func addStorageFlags(flags *flag.FlagSet) {
+	flags.StringVar(&dataDirFlag, "data-dir", "", "directory for persistent data (overrides DATA_DIR, default "+defaultDataDir+")")
+	flags.StringVar(&configFlag, "config", "", "declarative config file (overrides CONFIG_FILE, default "+defaultConfigFile+" if present)")
+}
+
+// newFlagSet creates the flag set of a command, with a usage line
+func newFlagSet(name, usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: gjallarhorn %s\n", usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags parses a command's flags, returning errUsage when they are invalid
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		return errUsage
+	}
+	return nil
+}
+
+// runCheckCommand checks a URL once. It exits non-zero when the check fails, for use in scripts.
+func runCheckCommand(args []string) error {
+	flags := newFlagSet("check", "check [flags] <url>")
+	verbose := flags.Bool("v", false, "show the server's log output for the check")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errUsage
+	}
+
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	target := flags.Arg(0)
+	result := runCheck(context.Background(), newCheckClient(), &Service{Name: target, URL: target})
+
+	status := "-"
+	if result.StatusCode != 0 {
+		status = fmt.Sprintf("HTTP %d", result.StatusCode)
+	}
+	fmt.Printf("%s\t%s\t%dms\t%s\n", result.Status, status, result.ResponseTime, target)
+	if result.CertExpiresAt != nil {
+		days := int(time.Until(*result.CertExpiresAt).Hours() / 24)
+		fmt.Printf("certificate expires %s (in %d days)\n", result.CertExpiresAt.Format(time.RFC3339), days)
+	}
+	if result.Err != nil {
+		return fmt.Errorf("check failed: %v", result.Err)
+	}
+	return nil
+}
+
+// apiClient calls the API of a running instance
+type apiClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+// addAPIFlags adds the flags that locate a running instance
+func addAPIFlags(flags *flag.FlagSet) *apiClient {
+	api := &apiClient{client: &http.Client{Timeout: 30 * time.Second}}
+
+	server := os.Getenv("GJALLARHORN_URL")
+	if server == "" {
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "8080"
+		}
+		server = "http://localhost:" + port
+	}
+	token := os.Getenv("GJALLARHORN_TOKEN")
+	if token == "" {
+		token = os.Getenv("API_TOKEN")
+	}
+
+	flags.StringVar(&api.server, "server", server, "URL of the Gjallarhorn instance (or GJALLARHORN_URL)")
+	flags.StringVar(&api.token, "token", token, "API token or key (or GJALLARHORN_TOKEN, then API_TOKEN)")
+	return api
+}
+
+// do calls an API endpoint, decoding the JSON response into out when it isn't nil. Error
+// responses are returned as errors, except those with one of the accepted statuses, which
+// are decoded like successful ones.
+func (a *apiClient) do(method, path string, body, out interface{}, accept ...int) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(a.server, "/")+"/api"+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	accepted := false
+	for _, status := range accept {
+		accepted = accepted || resp.StatusCode == status
+	}
+	if resp.StatusCode >= 300 && !accepted {
+		var apiError struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiError) == nil && apiError.Error != "" {
+			return fmt.Errorf("%s (HTTP %d)", apiError.Error, resp.StatusCode)
+		}
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// listServices returns the services of the instance, sorted by name
+func (a *apiClient) listServices() ([]Service, error) {
+	var services []Service
+	if err := a.do(http.MethodGet, "/services", nil, &services); err != nil {
+		return nil, fmt.Errorf("failed to list services: %v", err)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services, nil
+}
+
+// runServicesCommand manages the services of a running instance
+func runServicesCommand(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, "Usage: gjallarhorn services list|add|rm [flags]\n")
+		return errUsage
+	}
+
+	switch args[0] {
+	case "list", "ls":
+		return runServicesList(args[1:])
+	case "add":
+		return runServicesAdd(args[1:])
+	case "rm", "delete":
+		return runServicesRemove(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown services command '%s'\nUsage: gjallarhorn services list|add|rm [flags]\n", args[0])
+		return errUsage
+	}
+}
+
+// runServicesList prints the services as a table, or as JSON
+func runServicesList(args []string) error {
+	flags := newFlagSet("services list", "services list [flags]")
+	api := addAPIFlags(flags)
+	asJSON := flags.Bool("json", false, "print the services as JSON")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	services, err := api.listServices()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(services)
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tNAME\tSTATUS\tINTERVAL\tURL\tTAGS")
+	for _, service := range services {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%ds\t%s\t%s\n", service.ID, service.Name, service.Status,
+			service.Interval, service.URL, strings.Join(service.Tags, ","))
+	}
+	return table.Flush()
+}
+
+// runServicesAdd creates a service and prints its ID
+func runServicesAdd(args []string) error {
+	flags := newFlagSet("services add", "services add [flags] <url>")
+	api := addAPIFlags(flags)
+	name := flags.String("name", "", "service name (default the URL's host)")
+	interval := flags.Int("interval", 60, "check interval in seconds")
+	tags := flags.String("tags", "", "comma-separated tags")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errUsage
+	}
+
+	req := CreateServiceRequest{Name: *name, URL: flags.Arg(0), Interval: *interval}
+	if req.Name == "" {
+		if parsed, err := url.Parse(req.URL); err == nil && parsed.Host != "" {
+			req.Name = parsed.Host
+		}
+	}
+	for _, tag := range strings.Split(*tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			req.Tags = append(req.Tags, tag)
+		}
+	}
+
+	var service Service
+	if err := api.do(http.MethodPost, "/services", req, &service); err != nil {
+		return fmt.Errorf("failed to add service: %v", err)
+	}
+	fmt.Println(service.ID)
+	return nil
+}
+
+// runServicesRemove deletes services given by ID or by name
+func runServicesRemove(args []string) error {
+	flags := newFlagSet("services rm", "services rm [flags] <id|name>...")
+	api := addAPIFlags(flags)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	services, err := api.listServices()
+	if err != nil {
+		return err
+	}
+
+	// Resolve every argument before deleting anything, so a typo deletes nothing
+	var targets []Service
+	for _, arg := range flags.Args() {
+		var matches []Service
+		for _, service := range services {
+			if service.ID == arg {
+				matches = []Service{service}
+				break
+			}
+			if service.Name == arg {
+				matches = append(matches, service)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return fmt.Errorf("no service with ID or name '%s'", arg)
+		case 1:
+			targets = append(targets, matches[0])
+		default:
+			return fmt.Errorf("%d services are named '%s'; use the ID instead", len(matches), arg)
+		}
+	}
+
+	for _, service := range targets {
+		if err := api.do(http.MethodDelete, "/services/"+url.PathEscape(service.ID), nil, nil); err != nil {
+			return fmt.Errorf("failed to delete service %s: %v", service.Name, err)
+		}
+		fmt.Printf("Deleted %s (%s)\n", service.Name, service.ID)
+	}
+	return nil
+}
+
+// runConfigCommand works with the config file
+func runConfigCommand(args []string) error {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Fprint(os.Stderr, "Usage: gjallarhorn config validate [flags]\n")
+		return errUsage
+	}
+
+	flags := newFlagSet("config validate", "config validate [flags]")
+	flags.StringVar(&configFlag, "config", "", "config file to validate (overrides CONFIG_FILE, default "+defaultConfigFile+")")
+	if err := parseFlags(flags, args[1:]); err != nil {
+		return err
+	}
+
+	path := getConfigPath()
+	if path == "" {
+		return fmt.Errorf("no config file found; set -config or CONFIG_FILE")
+	}
+	config, err := LoadConfigFile(path, newValidator())
+	if err != nil {
+		return err
+	}
+
+	notifications := "not managed"
+	if config.notificationConfig() != nil {
+		notifications = "managed"
+	}
+	fmt.Printf("%s is valid: %d services, notifications %s\n", path, len(config.Services), notifications)
+	return nil
+}
+
+// runNotifyCommand works with notifications
+func runNotifyCommand(args []string) error {
+	if len(args) == 0 || args[0] != "test" {
+		fmt.Fprint(os.Stderr, "Usage: gjallarhorn notify test [flags]\n")
+		return errUsage
+	}
+
+	flags := newFlagSet("notify test", "notify test [flags]")
+	api := addAPIFlags(flags)
+	addStorageFlags(flags)
+	local := flags.Bool("local", false, "send from this process with the settings in the config file or data directory, without a running instance; don't use while the server runs")
+	channel := flags.String("channel", "", "channel to test: pushover or webhook (default every configured channel)")
+	if err := parseFlags(flags, args[1:]); err != nil {
+		return err
+	}
+	if *channel != "" && *channel != channelPushover && *channel != channelWebhook {
+		fmt.Fprintf(os.Stderr, "Unknown channel '%s', expected pushover or webhook\n", *channel)
+		return errUsage
+	}
+
+	var results []NotificationTestResult
+	if *local {
+		var err error
+		if results, err = sendLocalTestNotification(*channel); err != nil {
+			return err
+		}
+	} else {
+		// The server sends the test, so it lands in its delivery log and channel health
+		path := "/notifications/test"
+		if *channel != "" {
+			path += "?channel=" + url.QueryEscape(*channel)
+		}
+		var response NotificationTestResponse
+		if err := api.do(http.MethodPost, path, nil, &response, http.StatusBadGateway); err != nil {
+			return fmt.Errorf("failed to send test notification: %v", err)
+		}
+		results = response.Results
+	}
+
+	failed := 0
+	for _, result := range results {
+		if !result.Success {
+			failed++
+			fmt.Printf("%s: failed: %s\n", result.Channel, result.Error)
+			continue
+		}
+		fmt.Printf("%s: test notification sent (HTTP %d, %d ms)\n", result.Channel, result.StatusCode, result.Latency)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d test notifications failed", failed, len(results))
+	}
+	return nil
+}
+
+// sendLocalTestNotification sends a test notification from this process, for when no
+// instance is running. It opens the storage itself, so a running server would overwrite
+// the attempt it records and not see it in its channel health.
+func sendLocalTestNotification(channel string) ([]NotificationTestResult, error) {
+	// Use the settings the server would use: the config file's, otherwise the stored ones
+	var managed *NotificationConfig
+	if path := getConfigPath(); path != "" {
+		config, err := LoadConfigFile(path, newValidator())
+		if err != nil {
+			return nil, err
+		}
+		managed = config.notificationConfig()
+	}
+
+	store, err := NewStore()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage: %v", err)
+	}
+	defer store.Close()
+	notifications := NewNotificationService(store)
+	if managed != nil {
+		notifications.setConfig(managed)
+	}
+
+	config := notifications.currentConfig()
+	channels := config.channels()
+	if channel != "" {
+		if !config.configured(channel) {
+			return nil, fmt.Errorf("channel %s is not configured", channel)
+		}
+		channels = []string{channel}
+	}
+	if len(channels) == 0 {
+		return nil, fmt.Errorf("no notification channel is configured")
+	}
+
+	if !config.Enabled {
+		fmt.Println("Notifications are disabled; sending the test anyway")
+	}
+	return notifications.SendTestNotification(context.Background(), channels), nil
+}
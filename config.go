@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFile is loaded from the working directory when no config file is set
+const defaultConfigFile = "gjallarhorn.yaml"
+
+// errManagedService is returned when the API is used to change a service declared in the config file
+const errManagedService = "service is managed by the config file and can't be changed through the API"
+
+// configFlag holds the -config command line flag
+var configFlag string
+
+// ConfigFile is the declarative configuration loaded from gjallarhorn.yaml
+type ConfigFile struct {
+	Settings      ConfigSettings       `yaml:"settings"`
+	Services      []ConfigService      `yaml:"services"`
+	Notifications *ConfigNotifications `yaml:"notifications"` // Managed by the file when set
+}
+
+// ConfigSettings provide defaults for settings otherwise taken from environment variables.
+// Environment variables take precedence.
+type ConfigSettings struct {
+	CheckInterval int    `yaml:"checkInterval"` // CHECK_INTERVAL, in seconds
+	SkipTLSVerify *bool  `yaml:"skipTLSVerify"` // SKIP_TLS_VERIFY
+	HeartbeatURL  string `yaml:"heartbeatUrl"`  // HEARTBEAT_URL
+	Retention     struct {
+		RawDays    int `yaml:"rawDays"`    // RETENTION_RAW_DAYS
+		HourlyDays int `yaml:"hourlyDays"` // RETENTION_HOURLY_DAYS
+		DailyDays  int `yaml:"dailyDays"`  // RETENTION_DAILY_DAYS
+	} `yaml:"retention"`
+}
+
+// ConfigService declares a managed service. Services are matched to stored ones by
+// ID when set, otherwise by name; set an ID to rename a service without losing its history.
+type ConfigService struct {
+	ID       string   `yaml:"id"`
+	Name     string   `yaml:"name"`
+	URL      string   `yaml:"url"`
+	Interval int      `yaml:"interval"` // Defaults to 60 seconds
+	Tags     []string `yaml:"tags"`
+}
+
+// ConfigNotifications declares the notification channels
+type ConfigNotifications struct {
+	Pushover *ConfigPushover `yaml:"pushover"`
+	Webhook  *ConfigWebhook  `yaml:"webhook"`
+}
+
+// ConfigPushover declares the Pushover channel
+type ConfigPushover struct {
+	Enabled  bool   `yaml:"enabled"`
+	UserKey  string `yaml:"userKey"`
+	AppToken string `yaml:"appToken"`
+}
+
+// ConfigWebhook declares the webhook channel, which also receives notifications when Pushover fails repeatedly
+type ConfigWebhook struct {
+	URL string `yaml:"url"`
+}
+
+// configEnvPattern matches ${VAR} references, which are replaced with environment variables
+var configEnvPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// getConfigPath returns the config file from the -config flag or CONFIG_FILE, or
+// gjallarhorn.yaml when it exists. It returns "" when there is no config file.
+func getConfigPath() string {
+	if configFlag != "" {
+		return configFlag
+	}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		return path
+	}
+	if _, err := os.Stat(defaultConfigFile); err == nil {
+		return defaultConfigFile
+	}
+	return ""
+}
+
+// LoadConfigFile reads, expands and validates a config file
+func LoadConfigFile(path string, validator echo.Validator) (*ConfigFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	// Keep secrets out of git by referencing environment variables. Only values are
+	// expanded, so comments may mention ${VAR}.
+	var missing []string
+	expandConfigEnv(&root, &missing)
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("config file %s references unset environment variables: %v", path, missing)
+	}
+
+	expanded, err := yaml.Marshal(&root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	var config ConfigFile
+	decoder := yaml.NewDecoder(bytes.NewReader(expanded))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	if err := config.validate(validator); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	return &config, nil
+}
+
+// expandConfigEnv replaces ${VAR} references in scalar values, collecting unset variables
+func expandConfigEnv(node *yaml.Node, missing *[]string) {
+	if node.Kind == yaml.ScalarNode {
+		if !configEnvPattern.MatchString(node.Value) {
+			return
+		}
+		// Let the expanded value resolve to its own type, so numbers can come from variables too
+		node.Tag = ""
+		node.Value = configEnvPattern.ReplaceAllStringFunc(node.Value, func(ref string) string {
+			name := configEnvPattern.FindStringSubmatch(ref)[1]
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				*missing = append(*missing, name)
+			}
+			return value
+		})
+		return
+	}
+	for _, child := range node.Content {
+		expandConfigEnv(child, missing)
+	}
+}
+
+// validate checks the declared services and notification channels with the same rules as the API
+func (f *ConfigFile) validate(validator echo.Validator) error {
+	ids := make(map[string]bool)
+	names := make(map[string]bool)
+	for i := range f.Services {
+		service := &f.Services[i]
+		if service.Interval == 0 {
+			service.Interval = 60
+		}
+
+		req := CreateServiceRequest{Name: service.Name, URL: service.URL, Interval: service.Interval, Tags: service.Tags}
+		if err := validator.Validate(&req); err != nil {
+			return fmt.Errorf("service %d (%s): %v", i+1, service.Name, err)
+		}
+		if names[service.Name] {
+			return fmt.Errorf("duplicate service name '%s'", service.Name)
+		}
+		names[service.Name] = true
+		if service.ID != "" {
+			if ids[service.ID] {
+				return fmt.Errorf("duplicate service ID '%s'", service.ID)
+			}
+			ids[service.ID] = true
+		}
+	}
+
+	if f.Notifications != nil && f.Notifications.Webhook != nil {
+		if err := validateWebhookURL(f.Notifications.Webhook.URL); err != nil {
+			return fmt.Errorf("notifications.webhook: %v", err)
+		}
+	}
+	return nil
+}
+
+// applySettings exports the file's settings as environment variables that aren't already set
+func (f *ConfigFile) applySettings() {
+	settings := map[string]string{}
+	if f.Settings.CheckInterval != 0 {
+		settings["CHECK_INTERVAL"] = strconv.Itoa(f.Settings.CheckInterval)
+	}
+	if f.Settings.SkipTLSVerify != nil {
+		settings["SKIP_TLS_VERIFY"] = strconv.FormatBool(*f.Settings.SkipTLSVerify)
+	}
+	if f.Settings.HeartbeatURL != "" {
+		settings["HEARTBEAT_URL"] = f.Settings.HeartbeatURL
+	}
+	if f.Settings.Retention.RawDays != 0 {
+		settings["RETENTION_RAW_DAYS"] = strconv.Itoa(f.Settings.Retention.RawDays)
+	}
+	if f.Settings.Retention.HourlyDays != 0 {
+		settings["RETENTION_HOURLY_DAYS"] = strconv.Itoa(f.Settings.Retention.HourlyDays)
+	}
+	if f.Settings.Retention.DailyDays != 0 {
+		settings["RETENTION_DAILY_DAYS"] = strconv.Itoa(f.Settings.Retention.DailyDays)
+	}
+
+	for name, value := range settings {
+		if _, set := os.LookupEnv(name); set {
+			continue
+		}
+		os.Setenv(name, value)
+	}
+}
+
+// Apply reconciles the stored services and notification config with the config file
+func (f *ConfigFile) Apply(monitor *MonitorService, notifications *NotificationService) error {
+	created, updated, deleted, err := monitor.reconcileManagedServices(f.Services)
+	if err != nil {
+		return err
+	}
+	if created+updated+deleted > 0 {
+		log.Printf("Config file: %d services created, %d updated, %d deleted", created, updated, deleted)
+	}
+
+	config := f.notificationConfig()
+	if config == nil {
+		// Settings removed from the file become editable again
+		notifications.releaseManagedConfig()
+		return nil
+	}
+	return notifications.setManagedConfig(config)
+}
+
+// notificationConfig returns the declared notification config, or nil when the file doesn't manage notifications
+func (f *ConfigFile) notificationConfig() *NotificationConfig {
+	if f.Notifications == nil || (f.Notifications.Pushover == nil && f.Notifications.Webhook == nil) {
+		return nil
+	}
+
+	config := &NotificationConfig{Enabled: true}
+	if pushover := f.Notifications.Pushover; pushover != nil {
+		config.UserKey = pushover.UserKey
+		config.AppToken = pushover.AppToken
+		config.Enabled = pushover.Enabled
+	}
+	if webhook := f.Notifications.Webhook; webhook != nil {
+		config.WebhookURL = webhook.URL
+	}
+	return config
+}
+
+// reconcileManagedServices creates, updates and deletes services so the managed services
+// match the declared ones. Stored services with a declared name are adopted. The result
+// is saved before it replaces the running services, so a failed save changes nothing.
+func (m *MonitorService) reconcileManagedServices(declared []ConfigService) (created, updated, deleted int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	byName := make(map[string]*Service, len(m.services))
+	for _, service := range m.services {
+		byName[service.Name] = service
+	}
+
+	now := time.Now()
+	services := make(map[string]*Service, len(m.services))
+	var changed []*Service
+	for _, entry := range declared {
+		existing := m.services[entry.ID]
+		if existing == nil && entry.ID == "" {
+			existing = byName[entry.Name]
+		}
+		if existing != nil && services[existing.ID] != nil {
+			return 0, 0, 0, fmt.Errorf("service %s is declared twice in the config file", existing.ID)
+		}
+
+		if existing == nil {
+			id := entry.ID
+			if id == "" {
+				id = uuid.New().String()
+			}
+			service := &Service{
+				ID:        id,
+				Name:      entry.Name,
+				URL:       entry.URL,
+				Interval:  entry.Interval,
+				Tags:      entry.Tags,
+				Status:    "unknown",
+				Managed:   true,
+				CreatedAt: now,
+				UpdatedAt: now,
+			}
+			services[id] = service
+			changed = append(changed, service)
+			created++
+			continue
+		}
+
+		service := *existing
+		if service.Name != entry.Name || service.URL != entry.URL || service.Interval != entry.Interval ||
+			!equalStrings(service.Tags, entry.Tags) || !service.Managed {
+			service.Name = entry.Name
+			service.URL = entry.URL
+			service.Interval = entry.Interval
+			service.Tags = entry.Tags
+			service.Managed = true
+			service.UpdatedAt = now
+			changed = append(changed, &service)
+			updated++
+		}
+		services[service.ID] = &service
+	}
+
+	// Services no longer in the file are deleted; services created through the API are kept
+	var deletedIDs []string
+	for id, service := range m.services {
+		if _, declared := services[id]; declared {
+			continue
+		}
+		if service.Managed {
+			deletedIDs = append(deletedIDs, id)
+			continue
+		}
+		services[id] = service
+	}
+
+	if len(changed) == 0 && len(deletedIDs) == 0 {
+		return 0, 0, 0, nil
+	}
+
+	if err := m.storage.SaveServices(services); err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to save services from config file: %v", err)
+	}
+
+	previous := m.services
+	m.services = services
+	for _, service := range changed {
+		if _, existed := previous[service.ID]; existed {
+			relabelServiceMetrics(service)
+			m.publishServiceEvent("service.updated", service)
+		} else {
+			m.publishServiceEvent("service.created", service)
+		}
+	}
+	m.dropIncidentsLocked(deletedIDs...)
+	for _, id := range deletedIDs {
+		m.events.Publish(Event{Type: "service.deleted", ServiceID: id})
+		deleteServiceMetrics(id)
+	}
+
+	return created, updated, len(deletedIDs), nil
+}
+
+// equalStrings reports whether two string slices have the same elements in the same order
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// eventBufferSize is the number of events buffered per subscriber before events are dropped
+const eventBufferSize = 64
+
+// eventHeartbeatInterval keeps idle connections alive through proxies
+const eventHeartbeatInterval = 30 * time.Second
+
+// EventBroker fans out live update events to Server-Sent Events subscribers
+type EventBroker struct {
+	subscribers map[chan Event]struct{}
+	closed      bool
+	mu          sync.RWMutex
+}
+
+// NewEventBroker creates a new event broker
+func NewEventBroker() *EventBroker {
+	return &EventBroker{
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Subscribe registers a new subscriber channel
+func (b *EventBroker) Subscribe() chan Event {
+	ch := make(chan Event, eventBufferSize)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+// Unsubscribe removes a subscriber channel
+func (b *EventBroker) Unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	delete(b.subscribers, ch)
+	b.mu.Unlock()
+}
+
+// Close closes all subscriber channels, ending their streams, so the server can shut down
+func (b *EventBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for ch := range b.subscribers {
+		close(ch)
+		delete(b.subscribers, ch)
+	}
+}
+
+// Publish sends an event to all subscribers without blocking; slow subscribers miss events
+func (b *EventBroker) Publish(event Event) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("Warning: Dropping %s event for slow subscriber", event.Type)
+		}
+	}
+}
+
+// publishServiceEvent publishes an event carrying a snapshot of a service, assuming the lock is held
+func (m *MonitorService) publishServiceEvent(eventType string, service *Service) {
+	snapshot := *service
+	m.events.Publish(Event{
+		Type:      eventType,
+		ServiceID: service.ID,
+		Service:   &snapshot,
+	})
+}
+
+// StreamEvents streams live service updates as Server-Sent Events
+// @Summary Stream live updates
+// @Description Streams service changes and check results as Server-Sent Events. Each message is a JSON-encoded Event.
+// @Tags Services
+// @Produce text/event-stream
+// @Success 200 {object} Event
+// @Router /events [get]
+func (m *MonitorService) StreamEvents(c echo.Context) error {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no") // Disable nginx response buffering
+	res.WriteHeader(http.StatusOK)
+
+	// Tell the client how long to wait before reconnecting
+	fmt.Fprint(res, "retry: 5000\n\n")
+	res.Flush()
+
+	ch := m.events.Subscribe()
+	defer m.events.Unsubscribe(ch)
+
+	heartbeat := time.NewTicker(eventHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	ctx := c.Request().Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(res, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		case event, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("Error marshaling %s event: %v", event.Type, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(res, "data: %s\n\n", data); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// storagePingTimeout bounds how long readiness waits for storage
+const storagePingTimeout = 5 * time.Second
+
+// notificationBacklogAge is how long a due notification may wait for a worker before
+// notifications count as backed up
+const notificationBacklogAge = time.Minute
+
+// HealthService reports whether Gjallarhorn itself is alive and working
+type HealthService struct {
+	monitor       *MonitorService
+	notifications *NotificationService
+	storage       Store
+	pinging       atomic.Bool // A storage ping is running; a stuck one isn't started again
+}
+
+// NewHealthService creates a new health service
+func NewHealthService(monitor *MonitorService, notifications *NotificationService, storage Store) *HealthService {
+	return &HealthService{
+		monitor:       monitor,
+		notifications: notifications,
+		storage:       storage,
+	}
+}
+
+// Healthz reports that the process is alive
+// @Summary Liveness
+// @Description Returns 200 while the process serves requests. Use /readyz to tell whether monitoring works.
+// @Tags Health
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Router /healthz [get]
+func (h *HealthService) Healthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readyz reports whether storage works, the monitoring loop is running and notifications are being delivered
+// @Summary Readiness
+// @Description Returns 200 when storage can be read and written, the monitoring loop ran within the last two check intervals and no notification delivery is stuck, and 503 otherwise.
+// @Tags Health
+// @Produce json
+// @Success 200 {object} ReadinessResponse
+// @Failure 503 {object} ReadinessResponse
+// @Router /readyz [get]
+func (h *HealthService) Readyz(c echo.Context) error {
+	response := ReadinessResponse{
+		Status: "ready",
+		Checks: map[string]ReadinessCheck{
+			"storage":       readinessCheck(h.pingStorage()),
+			"monitoring":    readinessCheck(h.checkMonitoring()),
+			"notifications": readinessCheck(h.checkNotifications()),
+		},
+	}
+
+	for name, check := range response.Checks {
+		if check.Status != "ok" {
+			response.Status = "not ready"
+			log.Printf("Warning: Readiness check %s is failing: %s", name, check.Error)
+		}
+	}
+	if response.Status != "ready" {
+		return c.JSON(http.StatusServiceUnavailable, response)
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// readinessCheck converts the error of a check into its result
+func readinessCheck(err error) ReadinessCheck {
+	if err != nil {
+		return ReadinessCheck{Status: "failing", Error: err.Error()}
+	}
+	return ReadinessCheck{Status: "ok"}
+}
+
+// pingStorage checks storage without letting a hung database or disk block the request
+func (h *HealthService) pingStorage() error {
+	if !h.pinging.CompareAndSwap(false, true) {
+		return fmt.Errorf("a previous storage check has not finished")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer h.pinging.Store(false)
+		done <- h.storage.Ping()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Printf("Warning: Storage check failed: %v", err)
+			return fmt.Errorf("storage is not readable and writable")
+		}
+		return nil
+	case <-time.After(storagePingTimeout):
+		return fmt.Errorf("storage did not respond within %v", storagePingTimeout)
+	}
+}
+
+// checkMonitoring checks that the monitoring loop started a round recently
+func (h *HealthService) checkMonitoring() error {
+	lastTick := h.monitor.lastTick.Load()
+	if lastTick == 0 {
+		return fmt.Errorf("monitoring has not started")
+	}
+	select {
+	case <-h.monitor.stopped:
+		return fmt.Errorf("monitoring has stopped")
+	default:
+	}
+
+	since := time.Since(time.Unix(0, lastTick))
+	if limit := 2 * h.monitor.checkInterval; since > limit {
+		return fmt.Errorf("no monitoring round for %v, expected one every %v", since.Round(time.Second), h.monitor.checkInterval)
+	}
+	return nil
+}
+
+// checkNotifications checks that the delivery workers keep up with the queue. Deliveries
+// waiting to be retried don't count, so a Pushover outage doesn't make Gjallarhorn unready.
+func (h *HealthService) checkNotifications() error {
+	overdue, oldest := h.notifications.overdueDeliveries(time.Now())
+	if overdue > 0 && time.Since(oldest) > notificationBacklogAge {
+		return fmt.Errorf("%d notifications waiting for delivery, the oldest for %v", overdue, time.Since(oldest).Round(time.Second))
+	}
+	return nil
+}
+
+// Ping checks that the data directory can be written and the services file read
+func (s *FileStore) Ping() error {
+	tmp, err := os.CreateTemp(s.dataDir, ".ping-*")
+	if err != nil {
+		return fmt.Errorf("failed to write to data directory: %v", err)
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+
+	file, err := os.Open(s.servicesFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read services: %v", err)
+	}
+	return file.Close()
+}
+
+// Ping reads and writes the database in a transaction that is rolled back
+func (s *SQLStore) Ping() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM services`).Scan(&count); err != nil {
+		return fmt.Errorf("failed to read services: %v", err)
+	}
+	if _, err := tx.Exec(s.rebind(`INSERT INTO settings (key, value) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value`), "ping", time.Now().Format(time.RFC3339)); err != nil {
+		return fmt.Errorf("failed to write settings: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Interval limits of CreateServiceRequest; imported intervals are clamped to them
+const (
+	minImportInterval = 30
+	maxImportInterval = 3600
+)
+
+// kumaBackup is the part of an Uptime Kuma backup file that describes monitors
+type kumaBackup struct {
+	Version     string        `json:"version"`
+	MonitorList []kumaMonitor `json:"monitorList"`
+}
+
+// kumaMonitor is an Uptime Kuma monitor
+type kumaMonitor struct {
+	Name                string      `json:"name"`
+	Type                string      `json:"type"`
+	URL                 string      `json:"url"`
+	Method              string      `json:"method"`
+	Hostname            string      `json:"hostname"`
+	Port                int         `json:"port"`
+	Interval            int         `json:"interval"`
+	Active              interface{} `json:"active"` // true/false or 1/0 depending on the Kuma version
+	Keyword             string      `json:"keyword"`
+	InvertKeyword       bool        `json:"invertKeyword"`
+	AcceptedStatusCodes []string    `json:"accepted_statuscodes"`
+	Tags                []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"tags"`
+}
+
+// kumaUnsupportedTypes explains the Uptime Kuma monitor types Gjallarhorn has no check for
+var kumaUnsupportedTypes = map[string]string{
+	"port":  "TCP port checks are not supported",
+	"dns":   "DNS checks are not supported",
+	"ping":  "ping checks are not supported",
+	"push":  "push monitors are not supported",
+	"group": "monitor groups have no check of their own",
+}
+
+// parseKumaBackup translates the HTTP monitors of an Uptime Kuma backup (or a bare list
+// of monitors) into services
+func parseKumaBackup(body []byte) ([]importEntry, []ImportItem, error) {
+	var backup kumaBackup
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &backup.MonitorList); err != nil {
+			return nil, nil, err
+		}
+	} else if err := json.Unmarshal(body, &backup); err != nil {
+		return nil, nil, err
+	}
+
+	entries := []importEntry{}
+	unmapped := []ImportItem{}
+	for i, monitor := range backup.MonitorList {
+		if reason := kumaUnmappable(monitor); reason != "" {
+			target := monitor.URL
+			if target == "" && monitor.Hostname != "" {
+				target = monitor.Hostname
+				if monitor.Port != 0 {
+					target = fmt.Sprintf("%s:%d", monitor.Hostname, monitor.Port)
+				}
+			}
+			unmapped = append(unmapped, ImportItem{Index: i + 1, Name: monitor.Name, URL: target, Reason: reason})
+			continue
+		}
+
+		entry := importEntry{Index: i + 1, InventoryService: InventoryService{Name: monitor.Name, URL: monitor.URL}}
+		entry.Interval = clampImportInterval(monitor.Interval, &entry.Warnings)
+
+		switch monitor.Type {
+		case "keyword":
+			if monitor.InvertKeyword {
+				entry.Warnings = append(entry.Warnings, fmt.Sprintf("the absence of keyword '%s' is not checked", monitor.Keyword))
+			} else {
+				entry.Warnings = append(entry.Warnings, fmt.Sprintf("keyword '%s' is not checked", monitor.Keyword))
+			}
+		case "json-query":
+			entry.Warnings = append(entry.Warnings, "the JSON query is not checked")
+		}
+		if monitor.Method != "" && !strings.EqualFold(monitor.Method, "GET") {
+			entry.Warnings = append(entry.Warnings, fmt.Sprintf("checked with GET instead of %s", strings.ToUpper(monitor.Method)))
+		}
+		if len(monitor.AcceptedStatusCodes) > 0 && !equalStrings(monitor.AcceptedStatusCodes, []string{"200-299"}) {
+			entry.Warnings = append(entry.Warnings, fmt.Sprintf("accepted status codes %s are replaced by 2xx, 3xx and 401",
+				strings.Join(monitor.AcceptedStatusCodes, ", ")))
+		}
+		if active, ok := monitor.Active.(bool); (ok && !active) || monitor.Active == float64(0) {
+			entry.Warnings = append(entry.Warnings, "paused in Uptime Kuma, but will be checked")
+		}
+
+		seen := make(map[string]bool)
+		for _, tag := range monitor.Tags {
+			name := tag.Name
+			if tag.Value != "" {
+				name += ":" + tag.Value
+			}
+			if name != "" && !seen[name] {
+				seen[name] = true
+				entry.Tags = append(entry.Tags, name)
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+	return entries, unmapped, nil
+}
+
+// kumaUnmappable returns why an Uptime Kuma monitor can't be imported, or "" if it can
+func kumaUnmappable(monitor kumaMonitor) string {
+	switch monitor.Type {
+	case "http", "keyword", "json-query":
+		return ""
+	}
+	if reason, ok := kumaUnsupportedTypes[monitor.Type]; ok {
+		return reason
+	}
+	return fmt.Sprintf("'%s' monitors are not supported", monitor.Type)
+}
+
+// prometheusConfig is the part of a Prometheus config that lists scrape targets
+type prometheusConfig struct {
+	Global struct {
+		ScrapeInterval string `yaml:"scrape_interval"`
+	} `yaml:"global"`
+	ScrapeConfigs []prometheusScrapeConfig `yaml:"scrape_configs"`
+}
+
+// prometheusScrapeConfig is a Prometheus scrape job
+type prometheusScrapeConfig struct {
+	JobName        string                  `yaml:"job_name"`
+	ScrapeInterval string                  `yaml:"scrape_interval"`
+	MetricsPath    string                  `yaml:"metrics_path"`
+	Params         map[string][]string     `yaml:"params"`
+	StaticConfigs  []prometheusTargetGroup `yaml:"static_configs"`
+	Other          map[string]interface{}  `yaml:",inline"` // Service discovery and relabeling
+}
+
+// prometheusTargetGroup is a static_configs entry, which is also the file_sd format
+type prometheusTargetGroup struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+// parsePrometheusTargets translates the blackbox_exporter HTTP probes of a Prometheus
+// config into services. A bare target list in the file_sd format (YAML or JSON) is read
+// as HTTP probes.
+func parsePrometheusTargets(body []byte) ([]importEntry, []ImportItem, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(body, &root); err != nil {
+		return nil, nil, err
+	}
+
+	var config prometheusConfig
+	if len(root.Content) > 0 && root.Content[0].Kind == yaml.SequenceNode {
+		var groups []prometheusTargetGroup
+		if err := root.Decode(&groups); err != nil {
+			return nil, nil, err
+		}
+		config.ScrapeConfigs = []prometheusScrapeConfig{{StaticConfigs: groups}}
+	} else if err := root.Decode(&config); err != nil {
+		return nil, nil, err
+	}
+
+	entries := []importEntry{}
+	unmapped := []ImportItem{}
+	index := 0
+	for _, job := range config.ScrapeConfigs {
+		// Targets found through service discovery only exist at runtime
+		var discovery []string
+		for key := range job.Other {
+			if strings.HasSuffix(key, "_sd_configs") {
+				discovery = append(discovery, key)
+			}
+		}
+		sort.Strings(discovery)
+		for _, key := range discovery {
+			index++
+			unmapped = append(unmapped, ImportItem{Index: index, Name: job.JobName,
+				Reason: fmt.Sprintf("%s targets are discovered at runtime; only static_configs can be imported", key)})
+		}
+
+		module := ""
+		if modules := job.Params["module"]; len(modules) > 0 {
+			module = modules[0]
+		}
+		probe := module != "" || job.MetricsPath == "/probe" || job.JobName == ""
+
+		interval := job.ScrapeInterval
+		if interval == "" {
+			interval = config.Global.ScrapeInterval
+		}
+
+		for _, group := range job.StaticConfigs {
+			for _, target := range group.Targets {
+				index++
+				if !probe {
+					unmapped = append(unmapped, ImportItem{Index: index, Name: job.JobName, URL: target,
+						Reason: fmt.Sprintf("job '%s' scrapes metrics and is not a blackbox_exporter probe", job.JobName)})
+					continue
+				}
+
+				targetURL, reason := prometheusProbeURL(target, module)
+				if reason != "" {
+					unmapped = append(unmapped, ImportItem{Index: index, Name: job.JobName, URL: target, Reason: reason})
+					continue
+				}
+
+				entry := importEntry{Index: index, InventoryService: InventoryService{
+					Name: strings.TrimSuffix(strings.SplitN(targetURL, "://", 2)[1], "/"),
+					URL:  targetURL,
+				}}
+				entry.Interval = clampImportInterval(prometheusIntervalSeconds(interval, &entry.Warnings), &entry.Warnings)
+				if module != "" && module != "http_2xx" {
+					entry.Warnings = append(entry.Warnings, fmt.Sprintf("settings of module '%s' are not imported; 2xx, 3xx and 401 responses are healthy", module))
+				}
+
+				if job.JobName != "" {
+					entry.Tags = append(entry.Tags, "job:"+job.JobName)
+				}
+				labels := make([]string, 0, len(group.Labels))
+				for name := range group.Labels {
+					if !strings.HasPrefix(name, "__") {
+						labels = append(labels, name)
+					}
+				}
+				sort.Strings(labels)
+				for _, name := range labels {
+					entry.Tags = append(entry.Tags, name+":"+group.Labels[name])
+				}
+
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries, unmapped, nil
+}
+
+// prometheusProbeURL returns the URL a blackbox_exporter probe checks, or why it isn't an
+// HTTP probe. Like blackbox_exporter, HTTP modules default to http:// for bare targets.
+func prometheusProbeURL(target, module string) (string, string) {
+	if strings.Contains(target, "://") {
+		parsed, err := url.Parse(target)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return "", "only http and https targets can be imported"
+		}
+		return target, ""
+	}
+	if strings.HasPrefix(module, "http") {
+		return "http://" + target, ""
+	}
+	if module == "" {
+		return "", "target has no http:// or https:// scheme"
+	}
+	return "", fmt.Sprintf("module '%s' is not an HTTP probe", module)
+}
+
+// prometheusIntervalSeconds converts a Prometheus duration such as "30s" or "1m" to
+// seconds, defaulting to Prometheus' own default of one minute
+func prometheusIntervalSeconds(interval string, warnings *[]string) int {
+	if interval == "" {
+		return 60
+	}
+	duration, err := time.ParseDuration(interval)
+	if err != nil {
+		*warnings = append(*warnings, fmt.Sprintf("scrape interval '%s' is not understood, using 60 seconds", interval))
+		return 60
+	}
+	return int(duration.Seconds())
+}
+
+// clampImportInterval keeps an imported interval within the limits Gjallarhorn allows
+func clampImportInterval(interval int, warnings *[]string) int {
+	switch {
+	case interval == 0:
+		return 60
+	case interval < minImportInterval:
+		*warnings = append(*warnings, fmt.Sprintf("interval raised from %d to %d seconds", interval, minImportInterval))
+		return minImportInterval
+	case interval > maxImportInterval:
+		*warnings = append(*warnings, fmt.Sprintf("interval lowered from %d to %d seconds", interval, maxImportInterval))
+		return maxImportInterval
+	}
+	return interval
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/yaml.v3"
+)
+
+// maxImportSize limits the size of an import body
+const maxImportSize = 10 << 20
+
+// importEntry is a service to import, with its position in the import and notes on
+// anything lost translating it from another tool
+type importEntry struct {
+	InventoryService
+	Index    int
+	Warnings []string
+}
+
+// inventoryCSVHeader is the header row of CSV exports and imports. Tags are separated by semicolons.
+var inventoryCSVHeader = []string{"id", "name", "url", "interval", "tags"}
+
+// ExportServices returns the service inventory as JSON, YAML or CSV
+// @Summary Export services
+// @Description Exports the service configuration (without status) for POST /import. The YAML format matches the services list of the config file.
+// @Tags Services
+// @Produce json
+// @Produce plain
+// @Param format query string false "Export format" Enums(json, yaml, csv) default(json)
+// @Success 200 {object} ServiceInventory
+// @Failure 400 {object} map[string]string
+// @Router /export [get]
+func (m *MonitorService) ExportServices(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "json"
+	}
+
+	m.mu.RLock()
+	inventory := ServiceInventory{Services: make([]InventoryService, 0, len(m.services))}
+	for _, service := range m.services {
+		inventory.Services = append(inventory.Services, InventoryService{
+			ID:       service.ID,
+			Name:     service.Name,
+			URL:      service.URL,
+			Interval: service.Interval,
+			Tags:     service.Tags,
+		})
+	}
+	m.mu.RUnlock()
+	sort.Slice(inventory.Services, func(i, j int) bool { return inventory.Services[i].Name < inventory.Services[j].Name })
+
+	filename := fmt.Sprintf("gjallarhorn-services-%s.%s", time.Now().Format("20060102-150405"), format)
+	var data []byte
+	var contentType string
+	switch format {
+	case "json":
+		encoded, err := json.MarshalIndent(inventory, "", "  ")
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		data, contentType = encoded, echo.MIMEApplicationJSON
+	case "yaml":
+		encoded, err := yaml.Marshal(inventory)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		data, contentType = encoded, "application/yaml"
+	case "csv":
+		var buf bytes.Buffer
+		writer := csv.NewWriter(&buf)
+		writer.Write(inventoryCSVHeader)
+		for _, service := range inventory.Services {
+			writer.Write([]string{service.ID, service.Name, service.URL, strconv.Itoa(service.Interval), strings.Join(service.Tags, ";")})
+		}
+		writer.Flush()
+		data, contentType = buf.Bytes(), "text/csv"
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "format must be 'json', 'yaml' or 'csv'"})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	return c.Blob(http.StatusOK, contentType, data)
+}
+
+// ImportServices creates and updates services from a JSON, YAML or CSV inventory, or from an Uptime Kuma or Prometheus config
+// @Summary Import services
+// @Description Imports services exported by GET /export, an Uptime Kuma backup (format=kuma) or Prometheus blackbox targets (format=prometheus). Monitors that can't be translated are listed as unmapped. Entries are matched to existing services by ID, then by URL; matched services are updated and the rest are created. With dryRun=true, only the plan is returned. The import is all-or-nothing and is refused when any entry conflicts.
+// @Tags Services
+// @Accept json
+// @Accept plain
+// @Produce json
+// @Param format query string false "Import format, detected from Content-Type when omitted" Enums(json, yaml, csv, kuma, prometheus)
+// @Param dryRun query bool false "Only return the plan"
+// @Param services body ServiceInventory true "Services to import"
+// @Success 200 {object} ImportResponse
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} ImportResponse
+// @Router /import [post]
+func (m *MonitorService) ImportServices(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = importFormatFromContentType(c.Request().Header.Get(echo.HeaderContentType))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxImportSize+1))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to read request: " + err.Error()})
+	}
+	if len(body) > maxImportSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "import is larger than 10 MB"})
+	}
+
+	entries, unmapped, err := parseInventory(format, body)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format: " + err.Error()})
+	}
+
+	dryRun := c.QueryParam("dryRun") == "true"
+
+	m.mu.Lock()
+	plan, services := m.planImportLocked(c, entries)
+	plan.DryRun = dryRun
+	if unmapped != nil {
+		plan.Unmapped = unmapped
+	}
+
+	if dryRun {
+		m.mu.Unlock()
+		return c.JSON(http.StatusOK, plan)
+	}
+	if len(plan.Conflicts) > 0 {
+		m.mu.Unlock()
+		return c.JSON(http.StatusConflict, plan)
+	}
+	if len(plan.Created) == 0 && len(plan.Updated) == 0 {
+		m.mu.Unlock()
+		plan.Applied = true
+		return c.JSON(http.StatusOK, plan)
+	}
+
+	// Apply atomically - the import only replaces the running services once it is saved
+	if err := m.storage.SaveServices(services); err != nil {
+		m.mu.Unlock()
+		log.Printf("Error: Failed to save services to storage: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to persist import: " + err.Error(),
+		})
+	}
+	m.services = services
+	for _, item := range plan.Created {
+		m.publishServiceEvent("service.created", services[item.ID])
+	}
+	for _, item := range plan.Updated {
+		relabelServiceMetrics(services[item.ID])
+		m.publishServiceEvent("service.updated", services[item.ID])
+	}
+	m.mu.Unlock()
+
+	plan.Applied = true
+	log.Printf("Imported services: %d created, %d updated, %d unchanged, %d unmapped", len(plan.Created), len(plan.Updated), len(plan.Unchanged), len(plan.Unmapped))
+	return c.JSON(http.StatusOK, plan)
+}
+
+// importFormatFromContentType maps a Content-Type to an import format, defaulting to JSON
+func importFormatFromContentType(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return "yaml"
+	case "text/csv":
+		return "csv"
+	default:
+		return "json"
+	}
+}
+
+// parseInventory decodes an import in the given format. Entries of other tools that
+// can't be translated are returned as unmapped.
+func parseInventory(format string, body []byte) ([]importEntry, []ImportItem, error) {
+	var inventory ServiceInventory
+	switch format {
+	case "json":
+		if err := json.Unmarshal(body, &inventory); err != nil {
+			return nil, nil, err
+		}
+	case "yaml":
+		if err := yaml.Unmarshal(body, &inventory); err != nil {
+			return nil, nil, err
+		}
+	case "csv":
+		services, err := parseInventoryCSV(body)
+		if err != nil {
+			return nil, nil, err
+		}
+		inventory.Services = services
+	case "kuma":
+		return parseKumaBackup(body)
+	case "prometheus":
+		return parsePrometheusTargets(body)
+	default:
+		return nil, nil, fmt.Errorf("format must be 'json', 'yaml', 'csv', 'kuma' or 'prometheus'")
+	}
+
+	entries := make([]importEntry, len(inventory.Services))
+	for i, service := range inventory.Services {
+		entries[i] = importEntry{InventoryService: service, Index: i + 1}
+	}
+	return entries, nil, nil
+}
+
+// parseInventoryCSV decodes a CSV inventory. Columns are found by the header row, so
+// only name and url are required and the columns may come in any order.
+func parseInventoryCSV(body []byte) ([]InventoryService, error) {
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("missing header row")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"name", "url"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing '%s' column", required)
+		}
+	}
+
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	entries := make([]InventoryService, 0, len(rows)-1)
+	for line, row := range rows[1:] {
+		entry := InventoryService{
+			ID:   field(row, "id"),
+			Name: field(row, "name"),
+			URL:  field(row, "url"),
+		}
+		if interval := field(row, "interval"); interval != "" {
+			entry.Interval, err = strconv.Atoi(interval)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid interval '%s'", line+2, interval)
+			}
+		}
+		for _, tag := range strings.Split(field(row, "tags"), ";") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				entry.Tags = append(entry.Tags, tag)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// planImportLocked matches import entries to services and returns the plan along with
+// the services as they would be after the import, assuming the lock is held
+func (m *MonitorService) planImportLocked(c echo.Context, entries []importEntry) (*ImportResponse, map[string]*Service) {
+	plan := &ImportResponse{
+		Created:   []ImportItem{},
+		Updated:   []ImportItem{},
+		Unchanged: []ImportItem{},
+		Conflicts: []ImportItem{},
+		Unmapped:  []ImportItem{},
+	}
+
+	byURL := make(map[string][]*Service)
+	for _, service := range m.services {
+		byURL[service.URL] = append(byURL[service.URL], service)
+	}
+
+	services := make(map[string]*Service, len(m.services)+len(entries))
+	for id, service := range m.services {
+		services[id] = service
+	}
+
+	now := time.Now()
+	claimed := make(map[string]int)     // Service ID -> entry that matched or created it
+	createdURLs := make(map[string]int) // URL -> entry that creates it
+	for _, entry := range entries {
+		item := ImportItem{Index: entry.Index, ID: entry.ID, Name: entry.Name, URL: entry.URL, Warnings: entry.Warnings}
+		if entry.Interval == 0 {
+			entry.Interval = 60
+		}
+
+		req := CreateServiceRequest{Name: entry.Name, URL: entry.URL, Interval: entry.Interval, Tags: entry.Tags}
+		if err := c.Validate(&req); err != nil {
+			item.Reason = "invalid: " + err.Error()
+			plan.Conflicts = append(plan.Conflicts, item)
+			continue
+		}
+
+		var existing *Service
+		if service, ok := m.services[entry.ID]; ok && entry.ID != "" {
+			existing = service
+			item.MatchedBy = "id"
+		} else if matches := byURL[entry.URL]; len(matches) == 1 {
+			existing = matches[0]
+			item.MatchedBy = "url"
+		} else if len(matches) > 1 {
+			item.Reason = fmt.Sprintf("URL matches %d services; set the id to choose one", len(matches))
+			plan.Conflicts = append(plan.Conflicts, item)
+			continue
+		}
+
+		if existing == nil {
+			if other, ok := createdURLs[entry.URL]; ok {
+				item.Reason = fmt.Sprintf("same URL as entry %d", other)
+				plan.Conflicts = append(plan.Conflicts, item)
+				continue
+			}
+			if item.ID == "" {
+				item.ID = uuid.New().String()
+			} else if other, ok := claimed[item.ID]; ok {
+				item.Reason = fmt.Sprintf("same ID as entry %d", other)
+				plan.Conflicts = append(plan.Conflicts, item)
+				continue
+			}
+			createdURLs[entry.URL] = item.Index
+			claimed[item.ID] = item.Index
+			services[item.ID] = &Service{
+				ID:        item.ID,
+				Name:      entry.Name,
+				URL:       entry.URL,
+				Interval:  entry.Interval,
+				Tags:      entry.Tags,
+				Status:    "unknown",
+				CreatedAt: now,
+				UpdatedAt: now,
+			}
+			plan.Created = append(plan.Created, item)
+			continue
+		}
+
+		item.ID = existing.ID
+		if other, ok := claimed[existing.ID]; ok {
+			item.Reason = fmt.Sprintf("matches the same service as entry %d", other)
+			plan.Conflicts = append(plan.Conflicts, item)
+			continue
+		}
+		claimed[existing.ID] = item.Index
+
+		if existing.Name == entry.Name && existing.URL == entry.URL && existing.Interval == entry.Interval &&
+			equalStrings(existing.Tags, entry.Tags) {
+			plan.Unchanged = append(plan.Unchanged, item)
+			continue
+		}
+		if existing.Managed {
+			item.Reason = "service is managed by the config file"
+			plan.Conflicts = append(plan.Conflicts, item)
+			continue
+		}
+
+		updated := *existing
+		updated.Name = entry.Name
+		updated.URL = entry.URL
+		updated.Interval = entry.Interval
+		updated.Tags = entry.Tags
+		updated.UpdatedAt = now
+		services[existing.ID] = &updated
+		plan.Updated = append(plan.Updated, item)
+	}
+
+	return plan, services
+}
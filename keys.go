@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// API key scopes
+const (
+	ScopeRead               = "read"                // Read services, status, events and metrics
+	ScopeServicesWrite      = "services:write"      // Create, update and delete services
+	ScopeNotificationsAdmin = "notifications:admin" // Read and change notification credentials
+	ScopeAdmin              = "admin"               // Everything, including key management; never granted to keys
+)
+
+// apiKeyPrefix marks Gjallarhorn API keys so they can be told apart from other bearer tokens
+const apiKeyPrefix = "gjh_"
+
+// apiKeyLastUsedResolution limits how often key usage is written to storage
+const apiKeyLastUsedResolution = 5 * time.Minute
+
+// hashAPIKey returns the hex-encoded SHA-256 hash of a key
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// redactAPIKey returns a copy of a key that is safe to return from the API
+func redactAPIKey(key *APIKey) *APIKey {
+	redacted := *key
+	redacted.Hash = ""
+	return &redacted
+}
+
+// authenticateAPIKey resolves a bearer key to a principal
+func (a *AuthService) authenticateAPIKey(key string) *Principal {
+	hash := hashAPIKey(key)
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, apiKey := range a.keys {
+		if apiKey.Hash != hash {
+			continue
+		}
+		if apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt) {
+			return nil
+		}
+
+		// Record usage without rewriting the keys file on every request
+		if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyLastUsedResolution {
+			apiKey.LastUsedAt = &now
+			if err := a.storage.SaveAPIKeys(a.keys); err != nil {
+				log.Printf("Warning: Failed to record API key usage: %v", err)
+			}
+		}
+
+		return &Principal{Name: apiKey.Name, Method: "key", Scopes: apiKey.Scopes}
+	}
+	return nil
+}
+
+// RequireScope is middleware that rejects authenticated callers lacking a scope
+func (a *AuthService) RequireScope(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal, _ := c.Get(principalContextKey).(*Principal)
+			if principal == nil {
+				// Only reachable when authentication is disabled
+				return next(c)
+			}
+			if !principal.HasScope(scope) {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "missing required scope: " + scope})
+			}
+			return next(c)
+		}
+	}
+}
+
+// CreateAPIKey creates a new scoped API key
+// @Summary Create API key
+// @Description Creates a scoped API key. The key is only returned in this response; store it securely.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param key body CreateAPIKeyRequest true "Key to create"
+// @Success 201 {object} CreateAPIKeyResponse
+// @Failure 400 {object} map[string]string
+// @Security BearerAuth
+// @Router /keys [post]
+func (a *AuthService) CreateAPIKey(c echo.Context) error {
+	var req CreateAPIKeyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format: " + err.Error()})
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Validation failed: " + err.Error()})
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "expiresAt must be in the future"})
+	}
+
+	secret, err := generateToken(32)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate key: " + err.Error()})
+	}
+	key := apiKeyPrefix + secret
+
+	apiKey := &APIKey{
+		ID:        uuid.New().String(),
+		Name:      req.Name,
+		Prefix:    key[:len(apiKeyPrefix)+8],
+		Hash:      hashAPIKey(key),
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	a.mu.Lock()
+	a.keys = append(a.keys, apiKey)
+	if err := a.storage.SaveAPIKeys(a.keys); err != nil {
+		a.keys = a.keys[:len(a.keys)-1]
+		a.mu.Unlock()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to persist API key: " + err.Error()})
+	}
+	a.mu.Unlock()
+
+	return c.JSON(http.StatusCreated, CreateAPIKeyResponse{
+		APIKey: redactAPIKey(apiKey),
+		Key:    key,
+	})
+}
+
+// GetAPIKeys lists API keys without their secrets
+// @Summary List API keys
+// @Description Returns all API keys. Key secrets are never returned.
+// @Tags Auth
+// @Produce json
+// @Success 200 {array} APIKey
+// @Security BearerAuth
+// @Router /keys [get]
+func (a *AuthService) GetAPIKeys(c echo.Context) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	keys := make([]*APIKey, 0, len(a.keys))
+	for _, key := range a.keys {
+		keys = append(keys, redactAPIKey(key))
+	}
+
+	return c.JSON(http.StatusOK, keys)
+}
+
+// DeleteAPIKey revokes an API key
+// @Summary Delete API key
+// @Description Revokes an API key immediately
+// @Tags Auth
+// @Param id path string true "API key ID"
+// @Success 204
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /keys/{id} [delete]
+func (a *AuthService) DeleteAPIKey(c echo.Context) error {
+	id := c.Param("id")
+	if id == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "key ID is required"})
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for i, key := range a.keys {
+		if key.ID != id {
+			continue
+		}
+
+		remaining := make([]*APIKey, 0, len(a.keys)-1)
+		remaining = append(remaining, a.keys[:i]...)
+		remaining = append(remaining, a.keys[i+1:]...)
+		if err := a.storage.SaveAPIKeys(remaining); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to persist API keys: " + err.Error()})
+		}
+		a.keys = remaining
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	return c.JSON(http.StatusNotFound, map[string]string{"error": "API key not found"})
+}
+
+// isAPIKey reports whether a bearer token looks like a Gjallarhorn API key
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/joho/godotenv"
@@ -29,6 +36,16 @@ import (
 // @tag.description Bulk service operations with all-or-nothing semantics
 // @tag.name Notifications
 // @tag.description Notification configuration
+// @tag.name Badges
+// @tag.description Embeddable SVG status badges
+// @tag.name Auth
+// @tag.description Authentication and sessions
+// @tag.name Health
+// @tag.description Liveness and readiness of Gjallarhorn itself
+
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
 
 //go:embed dist/*
 var frontendFiles embed.FS
@@ -37,26 +54,37 @@ func main() {
 	// Load .env file
 	godotenv.Load()
 
+	os.Exit(runCLI(os.Args[1:]))
+}
+
+// serve runs the monitoring server until it receives SIGINT or SIGTERM, then shuts down gracefully
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addStorageFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: gjallarhorn [serve] [flags]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	log.Printf("Using data directory %s", getDataDir())
+
 	// Initialize Echo
 	e := echo.New()
+	e.Validator = newValidator()
 
-	// Initialize validator with custom validations
-	v := validator.New()
-
-	// Register custom validator for HTTP/HTTPS URLs only
-	v.RegisterValidation("httpurl", func(fl validator.FieldLevel) bool {
-		urlStr := fl.Field().String()
-		if urlStr == "" {
-			return false
-		}
-		u, err := url.Parse(urlStr)
+	// Load the config file before anything reads the settings it provides
+	var config *ConfigFile
+	configPath := getConfigPath()
+	if configPath != "" {
+		var err error
+		config, err = LoadConfigFile(configPath, e.Validator)
 		if err != nil {
-			return false
+			log.Fatalf("Failed to load config file: %v", err)
 		}
-		return u.Scheme == "http" || u.Scheme == "https"
-	})
-
-	e.Validator = &CustomValidator{validator: v}
+		config.applySettings()
+		log.Printf("Using config file %s", configPath)
+	}
 
 	// Middleware
 	e.Use(middleware.Logger())
@@ -67,31 +95,114 @@ func main() {
 		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
 	}))
 
+	// Open the store shared by all services
+	store, err := NewStore()
+	if err != nil {
+		log.Fatalf("Failed to open storage: %v", err)
+	}
+
 	// Initialize services
-	monitorService := NewMonitorService()
-	notificationService := NewNotificationService()
+	monitorService := NewMonitorService(store)
+	notificationService := NewNotificationService(store)
+	authService := NewAuthService(store)
+	backupService := NewBackupService(monitorService, notificationService, store)
+	healthService := NewHealthService(monitorService, notificationService, store)
+
+	// Reconcile stored state with the config file
+	if config != nil {
+		if err := config.Apply(monitorService, notificationService); err != nil {
+			log.Fatalf("Failed to apply config file: %v", err)
+		}
+	}
+
+	// Only trust forwarded client addresses from configured reverse proxies; otherwise
+	// use the connection's address so clients can't pick their own with a header
+	e.IPExtractor = echo.ExtractIPDirect()
+	if trusted := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES")); len(trusted) > 0 {
+		e.IPExtractor = newTrustedProxyIPExtractor(trusted)
+	}
+
+	// Background work runs until a shutdown signal cancels ctx
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
 	// Start background monitoring
-	go monitorService.StartMonitoring(notificationService)
+	go monitorService.StartMonitoring(ctx, notificationService)
+	notificationService.StartDelivery()
+	go monitorService.StartCompaction(ctx)
 
-	// API routes
+	// Alert when monitoring itself stalls
+	watchdog := NewWatchdog(monitorService, notificationService)
+	go watchdog.Start(ctx)
+
+	// Apply external changes to the config file and stored services without a restart
+	reloader := NewConfigReloader(configPath, config, e.Validator, monitorService, notificationService, store)
+	go reloader.Start(ctx)
+
+	// Public API routes
 	api := e.Group("/api")
-	api.GET("/services", monitorService.GetServices)
-	api.POST("/services", monitorService.CreateService)
-	api.PUT("/services/:id", monitorService.UpdateService)
-	api.DELETE("/services/:id", monitorService.DeleteService)
-	api.GET("/services/:id/status", monitorService.GetServiceStatus)
+	api.POST("/auth/login", authService.Login)
+	api.POST("/auth/logout", authService.Logout)
+	api.GET("/auth/me", authService.GetStatus)
+	api.GET("/auth/oidc/login", authService.OIDCLogin)
+	api.GET("/auth/oidc/callback", authService.OIDCCallback)
+
+	// Public badges
+	api.GET("/badge/:id/status.svg", monitorService.GetStatusBadge)
+	api.GET("/badge/:id/uptime.svg", monitorService.GetUptimeBadge)
+	api.GET("/badge/:id/latency.svg", monitorService.GetLatencyBadge)
+
+	// Authenticated API routes, each guarded by the scope it needs
+	protected := api.Group("", authService.RequireAuth)
+	read := authService.RequireScope(ScopeRead)
+	servicesWrite := authService.RequireScope(ScopeServicesWrite)
+	notificationsAdmin := authService.RequireScope(ScopeNotificationsAdmin)
+	admin := authService.RequireScope(ScopeAdmin)
+
+	protected.PUT("/auth/password", authService.ChangePassword, admin)
+	protected.GET("/services", monitorService.GetServices, read)
+	protected.POST("/services", monitorService.CreateService, servicesWrite)
+	protected.PUT("/services/:id", monitorService.UpdateService, servicesWrite)
+	protected.DELETE("/services/:id", monitorService.DeleteService, servicesWrite)
+	protected.GET("/services/:id/status", monitorService.GetServiceStatus, read)
+	protected.GET("/events", monitorService.StreamEvents, read)
+	protected.POST("/services/:id/badge-token", monitorService.CreateBadgeToken, servicesWrite)
+	protected.DELETE("/services/:id/badge-token", monitorService.DeleteBadgeToken, servicesWrite)
 
 	// Bulk operations
-	api.POST("/services/bulk", monitorService.BulkCreateServices)
-	api.PUT("/services/bulk", monitorService.BulkUpdateServices)
-	api.DELETE("/services/bulk", monitorService.BulkDeleteServices)
+	protected.POST("/services/bulk", monitorService.BulkCreateServices, servicesWrite)
+	protected.PUT("/services/bulk", monitorService.BulkUpdateServices, servicesWrite)
+	protected.DELETE("/services/bulk", monitorService.BulkDeleteServices, servicesWrite)
+
+	// Import and export
+	protected.GET("/export", monitorService.ExportServices, read)
+	protected.POST("/import", monitorService.ImportServices, servicesWrite)
 
 	// Notifications
-	api.POST("/notifications/config", notificationService.UpdateConfig)
-	api.GET("/notifications/config", func(c echo.Context) error {
+	protected.POST("/notifications/config", notificationService.UpdateConfig, notificationsAdmin)
+	protected.GET("/notifications/config", func(c echo.Context) error {
 		return c.JSON(http.StatusOK, notificationService.GetConfig())
-	})
+	}, notificationsAdmin)
+	protected.GET("/notifications/deliveries", notificationService.ListDeliveries, notificationsAdmin)
+	protected.GET("/notifications/attempts", notificationService.ListAttempts, notificationsAdmin)
+	protected.GET("/notifications/channels", notificationService.ListChannels, notificationsAdmin)
+	protected.POST("/notifications/test", notificationService.TestNotification, notificationsAdmin)
+
+	// API key management
+	protected.POST("/keys", authService.CreateAPIKey, admin)
+	protected.GET("/keys", authService.GetAPIKeys, admin)
+	protected.DELETE("/keys/:id", authService.DeleteAPIKey, admin)
+
+	// Backup and restore
+	protected.GET("/backup", backupService.Backup, admin)
+	protected.POST("/restore", backupService.Restore, admin)
+
+	// Liveness and readiness for orchestrators, without authentication
+	e.GET("/healthz", healthService.Healthz)
+	e.GET("/readyz", healthService.Readyz)
+
+	// Prometheus metrics
+	e.GET("/metrics", NewMetricsHandler(monitorService), authService.RequireAuth, read)
 
 	// Swagger documentation
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
@@ -151,8 +262,89 @@ func main() {
 		port = "8080"
 	}
 
+	// End live update streams on shutdown, as the server waits for open connections
+	e.Server.RegisterOnShutdown(monitorService.events.Close)
+
 	log.Printf("Starting Gjallarhorn server on port %s", port)
-	log.Fatal(e.Start(":" + port))
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- e.Start(":" + port)
+	}()
+
+	var exitErr error
+	select {
+	case <-ctx.Done():
+		log.Println("Received shutdown signal, shutting down")
+	case err := <-serverErr:
+		exitErr = fmt.Errorf("server failed: %v", err)
+		log.Printf("Error: Server failed, shutting down: %v", err)
+	}
+	// Stop background work, and let a second signal kill the process
+	stop()
+
+	shutdownTimeout := getShutdownTimeout()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// Stop accepting requests and wait for running ones
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Warning: Failed to finish running requests within %v: %v", shutdownTimeout, err)
+	}
+
+	// Wait for running checks, then persist the latest state
+	if err := monitorService.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Warning: Failed to stop monitoring cleanly: %v", err)
+	}
+
+	// Attempt the notifications that are due; the rest are sent after the next start
+	if err := notificationService.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Warning: Failed to stop notification delivery cleanly: %v", err)
+	}
+
+	if err := store.Close(); err != nil {
+		log.Printf("Warning: Failed to close storage: %v", err)
+	}
+	log.Println("Shutdown complete")
+	return exitErr
+}
+
+// getShutdownTimeout returns how long shutdown waits for running requests and checks
+func getShutdownTimeout() time.Duration {
+	timeoutStr := os.Getenv("SHUTDOWN_TIMEOUT")
+	if timeoutStr == "" {
+		return 25 * time.Second
+	}
+	timeout, err := strconv.Atoi(timeoutStr)
+	if err != nil || timeout < 1 {
+		log.Printf("Invalid SHUTDOWN_TIMEOUT '%s', using default 25 seconds", timeoutStr)
+		return 25 * time.Second
+	}
+	return time.Duration(timeout) * time.Second
+}
+
+// newValidator creates the validator for requests and the config file
+func newValidator() *CustomValidator {
+	v := validator.New()
+
+	// Register custom validator for HTTP/HTTPS URLs only
+	v.RegisterValidation("httpurl", func(fl validator.FieldLevel) bool {
+		urlStr := fl.Field().String()
+		if urlStr == "" {
+			return false
+		}
+		u, err := url.Parse(urlStr)
+		if err != nil {
+			return false
+		}
+		return u.Scheme == "http" || u.Scheme == "https"
+	})
+
+	// Tags are joined with commas in the metrics label, so they can't contain one
+	v.RegisterValidation("tag", func(fl validator.FieldLevel) bool {
+		return !strings.Contains(fl.Field().String(), ",")
+	})
+
+	return &CustomValidator{validator: v}
 }
 
 // CustomValidator wraps the go-playground validator
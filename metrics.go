@@ -0,0 +1,167 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// serviceLabels are the labels attached to every per-service metric
+var serviceLabels = []string{"id", "name", "tags"}
+
+var (
+	// checksTotal counts health checks by service and result
+	checksTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gjallarhorn_checks_total",
+		Help: "Total number of health checks performed, by service and result.",
+	}, append(append([]string(nil), serviceLabels...), "result"))
+
+	// notificationsSentTotal counts successfully delivered notifications
+	notificationsSentTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gjallarhorn_notifications_sent_total",
+		Help: "Total number of notifications delivered, by channel and event.",
+	}, []string{"channel", "event"})
+
+	// notificationsFailedTotal counts notifications that could not be delivered
+	notificationsFailedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gjallarhorn_notifications_failed_total",
+		Help: "Total number of notifications that failed to deliver, by channel and event.",
+	}, []string{"channel", "event"})
+)
+
+// checkSeriesLabels holds the name and tags label values of each service's check
+// counters, so the series can be dropped when a service is renamed or retagged
+var (
+	checkSeriesLabels   = make(map[string][2]string)
+	checkSeriesLabelsMu sync.Mutex
+)
+
+// deleteServiceMetrics drops the counters of a deleted service
+func deleteServiceMetrics(id string) {
+	checkSeriesLabelsMu.Lock()
+	delete(checkSeriesLabels, id)
+	checkSeriesLabelsMu.Unlock()
+	checksTotal.DeletePartialMatch(prometheus.Labels{"id": id})
+}
+
+// relabelServiceMetrics drops the counters of a service whose name or tags changed, so the
+// old labels don't linger as stale series
+func relabelServiceMetrics(service *Service) {
+	labels := [2]string{service.Name, formatTagsLabel(service.Tags)}
+
+	checkSeriesLabelsMu.Lock()
+	defer checkSeriesLabelsMu.Unlock()
+	if previous, ok := checkSeriesLabels[service.ID]; ok && previous != labels {
+		checksTotal.DeletePartialMatch(prometheus.Labels{"id": service.ID})
+	}
+	checkSeriesLabels[service.ID] = labels
+}
+
+// recordCheck counts a health check of a service
+func recordCheck(service *Service, result string) {
+	relabelServiceMetrics(service)
+	checksTotal.WithLabelValues(service.ID, service.Name, formatTagsLabel(service.Tags), result).Inc()
+}
+
+// recordNotification counts a notification delivery attempt
+func recordNotification(channel, event string, err error) {
+	if err != nil {
+		notificationsFailedTotal.WithLabelValues(channel, event).Inc()
+		return
+	}
+	notificationsSentTotal.WithLabelValues(channel, event).Inc()
+}
+
+// serviceCollector exposes per-service gauges from the live monitor state
+type serviceCollector struct {
+	monitor *MonitorService
+
+	up                  *prometheus.Desc
+	responseTime        *prometheus.Desc
+	consecutiveFailures *prometheus.Desc
+	certExpiry          *prometheus.Desc
+}
+
+// newServiceCollector creates a collector for the services of a monitor
+func newServiceCollector(monitor *MonitorService) *serviceCollector {
+	return &serviceCollector{
+		monitor: monitor,
+		up: prometheus.NewDesc("gjallarhorn_service_up",
+			"Whether the service is up (1), down (0) or not yet checked (-1).", serviceLabels, nil),
+		responseTime: prometheus.NewDesc("gjallarhorn_service_response_time_seconds",
+			"Response time of the last health check in seconds.", serviceLabels, nil),
+		consecutiveFailures: prometheus.NewDesc("gjallarhorn_service_consecutive_failures",
+			"Number of consecutive failed health checks.", serviceLabels, nil),
+		certExpiry: prometheus.NewDesc("gjallarhorn_service_certificate_expiry_timestamp_seconds",
+			"Expiry of the service's TLS certificate as a Unix timestamp.", serviceLabels, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (sc *serviceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sc.up
+	ch <- sc.responseTime
+	ch <- sc.consecutiveFailures
+	ch <- sc.certExpiry
+}
+
+// Collect implements prometheus.Collector
+func (sc *serviceCollector) Collect(ch chan<- prometheus.Metric) {
+	sc.monitor.mu.RLock()
+	defer sc.monitor.mu.RUnlock()
+
+	for _, service := range sc.monitor.services {
+		labels := []string{service.ID, service.Name, formatTagsLabel(service.Tags)}
+
+		up := -1.0
+		switch service.Status {
+		case "online":
+			up = 1
+		case "offline":
+			up = 0
+		}
+		ch <- prometheus.MustNewConstMetric(sc.up, prometheus.GaugeValue, up, labels...)
+		ch <- prometheus.MustNewConstMetric(sc.consecutiveFailures, prometheus.GaugeValue,
+			float64(service.ConsecutiveFailures), labels...)
+
+		if !service.LastChecked.IsZero() {
+			ch <- prometheus.MustNewConstMetric(sc.responseTime, prometheus.GaugeValue,
+				float64(service.LastResponseTime)/1000, labels...)
+		}
+		if service.CertExpiresAt != nil {
+			ch <- prometheus.MustNewConstMetric(sc.certExpiry, prometheus.GaugeValue,
+				float64(service.CertExpiresAt.Unix()), labels...)
+		}
+	}
+}
+
+// formatTagsLabel joins tags into a single label value such as ",prod,web,"
+// so they can be matched with regexes like tags=~".*,prod,.*"
+func formatTagsLabel(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+	return "," + strings.Join(sorted, ",") + ","
+}
+
+// NewMetricsHandler creates the Prometheus /metrics handler
+func NewMetricsHandler(monitor *MonitorService) echo.HandlerFunc {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		checksTotal,
+		notificationsSentTotal,
+		notificationsFailedTotal,
+		newServiceCollector(monitor),
+	)
+
+	return echo.WrapHandler(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+}
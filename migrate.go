@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fileMigration upgrades the JSON data files to a schema version
+type fileMigration struct {
+	version     int
+	description string
+	migrate     func(dataDir string) error
+}
+
+// fileMigrations are applied in order to data directories with an older schema version.
+// Migrations work on raw JSON so they keep working as the models change.
+var fileMigrations = []fileMigration{
+	{1, "backfill status and downtime fields of services saved before versioning", migrateFilesV1},
+}
+
+// sqlMigration upgrades a SQL database to a schema version
+type sqlMigration struct {
+	version     int
+	description string
+	statements  []string
+}
+
+// sqlMigrations are applied in order, each in its own transaction
+var sqlMigrations = []sqlMigration{
+	{1, "create tables", sqlSchema},
+	{2, "add check history rollups", []string{
+		`CREATE TABLE IF NOT EXISTS check_rollups (
+			service_id        TEXT NOT NULL,
+			period            TEXT NOT NULL,
+			bucket_start      BIGINT NOT NULL,
+			checks            BIGINT NOT NULL,
+			successes         BIGINT NOT NULL,
+			failures          BIGINT NOT NULL,
+			min_response_time BIGINT NOT NULL,
+			avg_response_time BIGINT NOT NULL,
+			max_response_time BIGINT NOT NULL,
+			p95_response_time BIGINT NOT NULL,
+			PRIMARY KEY (service_id, period, bucket_start)
+		)`,
+	}},
+	{3, "add notification delivery queue", []string{
+		`CREATE TABLE IF NOT EXISTS notification_deliveries (
+			id         TEXT PRIMARY KEY,
+			status     TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			data       TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS notification_deliveries_status_time ON notification_deliveries (status, created_at)`,
+	}},
+	{4, "add notification attempt log", []string{
+		`CREATE TABLE IF NOT EXISTS notification_attempts (
+			id           TEXT PRIMARY KEY,
+			channel      TEXT NOT NULL,
+			attempted_at BIGINT NOT NULL,
+			data         TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS notification_attempts_time ON notification_attempts (attempted_at)`,
+	}},
+}
+
+// sqlBackupTables are copied before a SQL database is migrated
+var sqlBackupTables = []string{"services", "notification_channels", "check_results", "check_rollups", "incidents", "notification_deliveries", "notification_attempts", "maintenance_windows", "settings"}
+
+// schemaMeta is stored in meta.json in the data directory
+type schemaMeta struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	MigratedAt    time.Time `json:"migratedAt"`
+}
+
+// latestFileSchemaVersion returns the schema version written by this build
+func latestFileSchemaVersion() int {
+	return fileMigrations[len(fileMigrations)-1].version
+}
+
+// backupDirName returns the name of the backup directory for a migration from a schema version
+func backupDirName(version int) string {
+	return fmt.Sprintf("pre-migration-v%d-%s", version, time.Now().Format("20060102-150405"))
+}
+
+// Migrate upgrades the data files to the latest schema version, copying them to
+// backups/ first. It refuses to touch data written by a newer version.
+func (s *FileStore) Migrate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.ensureDataDir(); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	latest := latestFileSchemaVersion()
+	version, exists, err := s.readSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > latest {
+		return fmt.Errorf("data in %s has schema version %d, but this version of Gjallarhorn only supports up to %d; upgrade Gjallarhorn or restore a backup",
+			s.dataDir, version, latest)
+	}
+	if version == latest {
+		return nil
+	}
+
+	// A data directory without data files is a fresh install and needs no migration
+	if !exists && !s.hasDataFiles() {
+		return s.writeSchemaVersion(latest)
+	}
+
+	backupDir := filepath.Join(s.dataDir, "backups", backupDirName(version))
+	if err := s.backupDataFiles(backupDir); err != nil {
+		return fmt.Errorf("failed to back up data before migrating: %v", err)
+	}
+	log.Printf("Backed up data files to %s before migrating from schema version %d to %d", backupDir, version, latest)
+
+	for _, migration := range fileMigrations {
+		if migration.version <= version {
+			continue
+		}
+		log.Printf("Migrating data files to schema version %d: %s", migration.version, migration.description)
+		if err := migration.migrate(s.dataDir); err != nil {
+			return fmt.Errorf("migration to schema version %d failed: %v (a backup is in %s)", migration.version, err, backupDir)
+		}
+		if err := s.writeSchemaVersion(migration.version); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readSchemaVersion reads meta.json. Data files without it predate versioning and are version 0.
+func (s *FileStore) readSchemaVersion() (version int, exists bool, err error) {
+	metaFile := filepath.Join(s.dataDir, "meta.json")
+	if _, err := os.Stat(metaFile); os.IsNotExist(err) {
+		return 0, false, nil
+	}
+
+	data, err := readFileWithFallback(metaFile)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read meta file: %v", err)
+	}
+
+	var meta schemaMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return 0, false, fmt.Errorf("failed to unmarshal meta file: %v", err)
+	}
+	return meta.SchemaVersion, true, nil
+}
+
+// writeSchemaVersion records the schema version of the data files in meta.json
+func (s *FileStore) writeSchemaVersion(version int) error {
+	data, err := json.MarshalIndent(schemaMeta{SchemaVersion: version, MigratedAt: time.Now()}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal meta file: %v", err)
+	}
+	if err := writeFileAtomic(filepath.Join(s.dataDir, "meta.json"), data); err != nil {
+		return fmt.Errorf("failed to write meta file: %v", err)
+	}
+	return nil
+}
+
+// dataFiles returns the paths of all data files
+func (s *FileStore) dataFiles() []string {
+	return []string{s.servicesFile, s.configFile, s.incidentsFile, s.authFile, s.keysFile, s.deliveriesFile, s.attemptsFile}
+}
+
+// hasDataFiles reports whether any data file exists
+func (s *FileStore) hasDataFiles() bool {
+	for _, path := range s.dataFiles() {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// backupDataFiles copies the existing data files into a directory
+func (s *FileStore) backupDataFiles(backupDir string) error {
+	if err := os.MkdirAll(backupDir, 0700); err != nil {
+		return err
+	}
+
+	for _, path := range append(s.dataFiles(), filepath.Join(s.dataDir, "meta.json")) {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(backupDir, filepath.Base(path)), data, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateFilesV1 fixes services saved before ConsecutiveFailures and WentOfflineAt existed.
+// Without a failure count, an offline service would flip back to online on its next failed check.
+func migrateFilesV1(dataDir string) error {
+	servicesFile := filepath.Join(dataDir, "services.json")
+	if _, err := os.Stat(servicesFile); os.IsNotExist(err) {
+		return nil
+	}
+
+	data, err := readFileWithFallback(servicesFile)
+	if err != nil {
+		return err
+	}
+
+	var services map[string]map[string]interface{}
+	if err := json.Unmarshal(data, &services); err != nil {
+		return fmt.Errorf("failed to unmarshal services: %v", err)
+	}
+
+	for _, service := range services {
+		status, _ := service["status"].(string)
+		if status == "" {
+			service["status"] = "unknown"
+		}
+		if status != "offline" {
+			continue
+		}
+		if failures, _ := service["consecutiveFailures"].(float64); failures < 3 {
+			service["consecutiveFailures"] = 3
+		}
+		if _, ok := service["wentOfflineAt"]; !ok {
+			// The best available estimate of when the outage started
+			if lastChecked, ok := service["lastChecked"]; ok {
+				service["wentOfflineAt"] = lastChecked
+				service["lastReminderAt"] = lastChecked
+			}
+		}
+	}
+
+	migrated, err := json.MarshalIndent(services, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal services: %v", err)
+	}
+	return writeFileAtomic(servicesFile, migrated)
+}
+
+// migrate applies pending SQL migrations. Databases that already have a schema
+// version are backed up first: SQLite into a file in backupDir, PostgreSQL into
+// backup_v<version>_<table> tables.
+func (s *SQLStore) migrate(backupDir string) error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    BIGINT PRIMARY KEY,
+		applied_at BIGINT NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+
+	var version int
+	if err := s.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	latest := sqlMigrations[len(sqlMigrations)-1].version
+	if version > latest {
+		return fmt.Errorf("database has schema version %d, but this version of Gjallarhorn only supports up to %d; upgrade Gjallarhorn or restore a backup",
+			version, latest)
+	}
+	if version == latest {
+		return nil
+	}
+
+	// Version 0 is a new database, or one created before versioning whose
+	// tables migration 1 leaves untouched
+	if version > 0 {
+		if err := s.backup(version, backupDir); err != nil {
+			return fmt.Errorf("failed to back up database before migrating: %v", err)
+		}
+	}
+
+	autoincrement := "INTEGER PRIMARY KEY AUTOINCREMENT"
+	if s.dialect == dialectPostgres {
+		autoincrement = "BIGSERIAL PRIMARY KEY"
+	}
+
+	for _, migration := range sqlMigrations {
+		if migration.version <= version {
+			continue
+		}
+		if version > 0 {
+			log.Printf("Migrating database to schema version %d: %s", migration.version, migration.description)
+		}
+
+		tx, err := s.db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration: %v", err)
+		}
+		for _, stmt := range migration.statements {
+			if _, err := tx.Exec(strings.ReplaceAll(stmt, "{{autoincrement}}", autoincrement)); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration to schema version %d failed: %v", migration.version, err)
+			}
+		}
+		if _, err := tx.Exec(s.rebind("INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)"),
+			migration.version, time.Now().UnixMilli()); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record schema version %d: %v", migration.version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration to schema version %d: %v", migration.version, err)
+		}
+	}
+
+	return nil
+}
+
+// backup copies the database before a migration
+func (s *SQLStore) backup(version int, backupDir string) error {
+	if s.dialect == dialectSQLite {
+		if err := os.MkdirAll(backupDir, 0700); err != nil {
+			return err
+		}
+		path := filepath.Join(backupDir, backupDirName(version)+".db")
+		if _, err := s.db.Exec("VACUUM INTO ?", path); err != nil {
+			return err
+		}
+		log.Printf("Backed up database to %s before migrating", path)
+		return nil
+	}
+
+	for _, table := range sqlBackupTables {
+		// Tables added by later migrations don't exist yet
+		var exists bool
+		if err := s.db.QueryRow("SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		backupTable := fmt.Sprintf("backup_v%d_%s", version, table)
+		if _, err := s.db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", backupTable)); err != nil {
+			return err
+		}
+		if _, err := s.db.Exec(fmt.Sprintf("CREATE TABLE %s AS SELECT * FROM %s", backupTable, table)); err != nil {
+			return err
+		}
+	}
+	log.Printf("Backed up tables to backup_v%d_* before migrating", version)
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sqlSchemaVersion returns the highest schema version recorded in a database
+func sqlSchemaVersion(t *testing.T, s *SQLStore) int {
+	t.Helper()
+	var version int
+	if err := s.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		t.Fatalf("failed to read schema version: %v", err)
+	}
+	return version
+}
+
+// sqlTableExists reports whether a table exists in the store's database
+func sqlTableExists(t *testing.T, s *SQLStore, table string) bool {
+	t.Helper()
+	query := "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+	if s.dialect == dialectPostgres {
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?"
+	}
+	var count int
+	if err := s.db.QueryRow(s.rebind(query), table).Scan(&count); err != nil {
+		t.Fatalf("failed to look up table %s: %v", table, err)
+	}
+	return count > 0
+}
+
+// withSQLMigrations runs f with only the first migrations up to a schema version defined
+func withSQLMigrations(version int, f func()) {
+	all := sqlMigrations
+	defer func() { sqlMigrations = all }()
+	for i, migration := range all {
+		if migration.version == version {
+			sqlMigrations = all[:i+1]
+		}
+	}
+	f()
+}
+
+func TestSQLMigrations(t *testing.T) {
+	latest := sqlMigrations[len(sqlMigrations)-1].version
+
+	t.Run("new database", func(t *testing.T) {
+		forEachSQLDatabase(t, func(t *testing.T, dialect, dsn string) {
+			s := openTestSQLStore(t, dialect, dsn)
+			if version := sqlSchemaVersion(t, s); version != latest {
+				t.Errorf("schema version = %d, want %d", version, latest)
+			}
+			for _, table := range sqlBackupTables {
+				if !sqlTableExists(t, s, table) {
+					t.Errorf("table %s was not created", table)
+				}
+			}
+		})
+	})
+
+	for _, migration := range sqlMigrations[:len(sqlMigrations)-1] {
+		from := migration.version
+		t.Run(fmt.Sprintf("upgrade from version %d", from), func(t *testing.T) {
+			forEachSQLDatabase(t, func(t *testing.T, dialect, dsn string) {
+				backupDir := t.TempDir()
+				withSQLMigrations(from, func() {
+					s, err := NewSQLStore(dialect, dsn, backupDir)
+					if err != nil {
+						t.Fatalf("NewSQLStore at version %d failed: %v", from, err)
+					}
+					if version := sqlSchemaVersion(t, s); version != from {
+						t.Fatalf("schema version = %d, want %d", version, from)
+					}
+					if err := s.SaveService(&Service{ID: "web", Name: "Web"}); err != nil {
+						t.Fatalf("SaveService failed: %v", err)
+					}
+					s.Close()
+				})
+
+				s, err := NewSQLStore(dialect, dsn, backupDir)
+				if err != nil {
+					t.Fatalf("NewSQLStore failed to upgrade from version %d: %v", from, err)
+				}
+				defer s.Close()
+				if version := sqlSchemaVersion(t, s); version != latest {
+					t.Errorf("schema version after upgrade = %d, want %d", version, latest)
+				}
+				for _, table := range sqlBackupTables {
+					if !sqlTableExists(t, s, table) {
+						t.Errorf("table %s was not created by the upgrade", table)
+					}
+				}
+				if services, err := s.LoadServices(); err != nil || services["web"] == nil {
+					t.Errorf("services after upgrade = %v, %v; want web", services, err)
+				}
+
+				// The database is backed up before it is migrated
+				if dialect == dialectSQLite {
+					backups, _ := filepath.Glob(filepath.Join(backupDir, fmt.Sprintf("pre-migration-v%d-*.db", from)))
+					if len(backups) != 1 {
+						t.Errorf("found backups %v in %s, want one of schema version %d", backups, backupDir, from)
+					}
+				} else if !sqlTableExists(t, s, fmt.Sprintf("backup_v%d_services", from)) {
+					t.Errorf("table backup_v%d_services was not created", from)
+				}
+			})
+		})
+	}
+
+	t.Run("reopening a migrated database", func(t *testing.T) {
+		forEachSQLDatabase(t, func(t *testing.T, dialect, dsn string) {
+			backupDir := t.TempDir()
+			for i := 0; i < 2; i++ {
+				s, err := NewSQLStore(dialect, dsn, backupDir)
+				if err != nil {
+					t.Fatalf("NewSQLStore failed on open %d: %v", i+1, err)
+				}
+				s.Close()
+			}
+			if backups, _ := os.ReadDir(backupDir); len(backups) != 0 {
+				t.Errorf("reopening an up-to-date database made backups %v", backups)
+			}
+		})
+	})
+
+	t.Run("database from a newer version", func(t *testing.T) {
+		forEachSQLDatabase(t, func(t *testing.T, dialect, dsn string) {
+			s := openTestSQLStore(t, dialect, dsn)
+			if _, err := s.exec("INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)", latest+1, time.Now().UnixMilli()); err != nil {
+				t.Fatalf("failed to record a newer schema version: %v", err)
+			}
+			s.Close()
+
+			if s, err := NewSQLStore(dialect, dsn, t.TempDir()); err == nil {
+				s.Close()
+				t.Fatal("NewSQLStore opened a database with a newer schema version")
+			} else if !strings.Contains(err.Error(), "only supports up to") {
+				t.Errorf("NewSQLStore error = %v, want it to name the supported version", err)
+			}
+		})
+	})
+}
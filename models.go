@@ -11,6 +11,7 @@ type Service struct {
 	URL         string    `json:"url"`
 	Interval    int       `json:"interval"` // in seconds
 	Status      string    `json:"status"`   // "online", "offline", "unknown"
+	Tags        []string  `json:"tags,omitempty"`
 	LastChecked time.Time `json:"lastChecked"`
 	CreatedAt   time.Time `json:"createdAt"`
 	UpdatedAt   time.Time `json:"updatedAt"`
@@ -19,6 +20,13 @@ type Service struct {
 	LastReminderAt *time.Time `json:"lastReminderAt,omitempty"` // When last reminder was sent
 	// Failure tracking
 	ConsecutiveFailures int `json:"consecutiveFailures"` // Number of consecutive failed checks
+	// Last check details
+	LastResponseTime int64      `json:"lastResponseTime"`        // in milliseconds
+	CertExpiresAt    *time.Time `json:"certExpiresAt,omitempty"` // Expiry of the leaf TLS certificate, for HTTPS services
+	// Public badge access
+	BadgeToken string `json:"badgeToken,omitempty"` // When set, badges are only served by token
+	// Config-as-code
+	Managed bool `json:"managed,omitempty"` // Declared in the config file and read-only through the API
 }
 
 // ServiceStatus represents the current status of a service
@@ -32,32 +40,141 @@ type ServiceStatus struct {
 
 // CheckResult represents the result of a health check
 type CheckResult struct {
-	ServiceID    string
-	Status       string
-	ResponseTime int64
-	Error        error
-	Timestamp    time.Time
+	ServiceID    string    `json:"serviceId"`
+	Status       string    `json:"status"`       // "online" or "failed"
+	ResponseTime int64     `json:"responseTime"` // in milliseconds
+	Error        string    `json:"error,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// CheckRollup aggregates the check results of a service over an hour or a day.
+// Latency figures only cover successful checks, as failed checks mostly measure timeouts.
+type CheckRollup struct {
+	ServiceID       string    `json:"serviceId"`
+	Period          string    `json:"period"` // "hour" or "day"
+	BucketStart     time.Time `json:"bucketStart"`
+	Checks          int       `json:"checks"`
+	Successes       int       `json:"successes"`
+	Failures        int       `json:"failures"`
+	MinResponseTime int64     `json:"minResponseTime"` // in milliseconds
+	AvgResponseTime int64     `json:"avgResponseTime"`
+	MaxResponseTime int64     `json:"maxResponseTime"`
+	P95ResponseTime int64     `json:"p95ResponseTime"`
+}
+
+// MaintenanceWindow represents a planned period of downtime.
+// An empty ServiceID applies the window to all services.
+type MaintenanceWindow struct {
+	ID        string    `json:"id"`
+	ServiceID string    `json:"serviceId,omitempty"`
+	StartsAt  time.Time `json:"startsAt"`
+	EndsAt    time.Time `json:"endsAt"`
+	Reason    string    `json:"reason,omitempty"`
 }
 
-// NotificationConfig holds Pushover configuration
+// Incident represents a period during which a service was offline
+type Incident struct {
+	ID         string     `json:"id"`
+	ServiceID  string     `json:"serviceId"`
+	StartedAt  time.Time  `json:"startedAt"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"` // nil while the incident is ongoing
+	Error      string     `json:"error,omitempty"`
+}
+
+// Event represents a live update pushed to event stream subscribers
+type Event struct {
+	Type           string    `json:"type"` // "service.created", "service.updated", "service.deleted", "service.checked", "service.status"
+	ServiceID      string    `json:"serviceId"`
+	Service        *Service  `json:"service,omitempty"` // Snapshot of the service, omitted for deletions
+	PreviousStatus string    `json:"previousStatus,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// NotificationConfig holds the notification channel configuration
 type NotificationConfig struct {
-	UserKey  string `json:"userKey"`
-	AppToken string `json:"appToken"`
-	Enabled  bool   `json:"enabled"`
+	UserKey    string `json:"userKey"`
+	AppToken   string `json:"appToken"`
+	WebhookURL string `json:"webhookUrl,omitempty"` // Receives notifications as JSON, and when Pushover fails repeatedly
+	Enabled    bool   `json:"enabled"`
+}
+
+// NotificationDelivery is a queued notification and the state of its delivery.
+// Credentials are not stored; they are read from the notification config when sending.
+type NotificationDelivery struct {
+	ID            string     `json:"id"`
+	Channel       string     `json:"channel"` // Channel of the last attempt: "pushover" or "webhook"
+	Event         string     `json:"event"`   // "down", "reminder", "recovery", "watchdog", "watchdog_recovery"
+	ServiceID     string     `json:"serviceId,omitempty"`
+	Title         string     `json:"title"`
+	Message       string     `json:"message"`
+	Sound         string     `json:"sound,omitempty"`
+	Priority      string     `json:"priority"`
+	Status        string     `json:"status"` // "pending", "delivered" or "failed"
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"lastError,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	NextAttemptAt time.Time  `json:"nextAttemptAt"`
+	DeliveredAt   *time.Time `json:"deliveredAt,omitempty"`
+	Broadcast     bool       `json:"broadcast,omitempty"` // Queued once per channel and sent through Channel only, without fallback
+}
+
+// NotificationAttempt records one attempt to send a notification through a channel
+type NotificationAttempt struct {
+	ID          string    `json:"id"`
+	DeliveryID  string    `json:"deliveryId,omitempty"` // Empty for test notifications
+	Channel     string    `json:"channel"`
+	Event       string    `json:"event"`
+	ServiceID   string    `json:"serviceId,omitempty"`
+	StatusCode  int       `json:"statusCode,omitempty"` // HTTP status of the provider's response; 0 when there was none
+	Error       string    `json:"error,omitempty"`
+	Latency     int64     `json:"latency"`            // in milliseconds
+	Fallback    bool      `json:"fallback,omitempty"` // Sent because an earlier channel failed repeatedly
+	AttemptedAt time.Time `json:"attemptedAt"`
+}
+
+// NotificationTestResult is the outcome of a test notification through a channel
+type NotificationTestResult struct {
+	Channel    string `json:"channel"`
+	Success    bool   `json:"success"`
+	StatusCode int    `json:"statusCode,omitempty"` // HTTP status of the provider's response; 0 when there was none
+	Response   string `json:"response,omitempty"`   // Body of the provider's response
+	Error      string `json:"error,omitempty"`
+	Latency    int64  `json:"latency"` // in milliseconds
+}
+
+// NotificationTestResponse reports the test notifications sent through each channel
+type NotificationTestResponse struct {
+	Success bool                     `json:"success"` // Every channel accepted its test notification
+	Results []NotificationTestResult `json:"results"`
+}
+
+// NotificationChannelStatus reports whether a notification channel is delivering
+type NotificationChannelStatus struct {
+	Channel             string     `json:"channel"`
+	Configured          bool       `json:"configured"`
+	Healthy             bool       `json:"healthy"` // False after repeated consecutive failures
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastError           string     `json:"lastError,omitempty"`
+	LastAttemptAt       *time.Time `json:"lastAttemptAt,omitempty"`
+	LastSuccessAt       *time.Time `json:"lastSuccessAt,omitempty"`
 }
 
 // CreateServiceRequest represents the request to create a new service
 type CreateServiceRequest struct {
-	Name     string `json:"name" validate:"required,min=1,max=100"`
-	URL      string `json:"url" validate:"required,httpurl,max=2048"`
-	Interval int    `json:"interval" validate:"required,min=30,max=3600"`
+	Name     string   `json:"name" validate:"required,min=1,max=100"`
+	URL      string   `json:"url" validate:"required,httpurl,max=2048"`
+	Interval int      `json:"interval" validate:"required,min=30,max=3600"`
+	Tags     []string `json:"tags,omitempty" validate:"max=20,dive,min=1,max=50,tag"`
 }
 
-// UpdateServiceRequest represents the request to update a service
+// UpdateServiceRequest represents the request to update a service.
+// Tags are left unchanged when omitted.
 type UpdateServiceRequest struct {
-	Name     string `json:"name" validate:"required,min=1,max=100"`
-	URL      string `json:"url" validate:"required,httpurl,max=2048"`
-	Interval int    `json:"interval" validate:"required,min=30,max=3600"`
+	Name     string   `json:"name" validate:"required,min=1,max=100"`
+	URL      string   `json:"url" validate:"required,httpurl,max=2048"`
+	Interval int      `json:"interval" validate:"required,min=30,max=3600"`
+	Tags     []string `json:"tags,omitempty" validate:"max=20,dive,min=1,max=50,tag"`
 }
 
 // BulkCreateServiceRequest represents a request to create multiple services
@@ -67,10 +184,11 @@ type BulkCreateServiceRequest struct {
 
 // BulkUpdateServiceItem represents a single service update in a bulk request
 type BulkUpdateServiceItem struct {
-	ID       string `json:"id" validate:"required"`
-	Name     string `json:"name" validate:"required,min=1,max=100"`
-	URL      string `json:"url" validate:"required,httpurl,max=2048"`
-	Interval int    `json:"interval" validate:"required,min=30,max=3600"`
+	ID       string   `json:"id" validate:"required"`
+	Name     string   `json:"name" validate:"required,min=1,max=100"`
+	URL      string   `json:"url" validate:"required,httpurl,max=2048"`
+	Interval int      `json:"interval" validate:"required,min=30,max=3600"`
+	Tags     []string `json:"tags,omitempty" validate:"max=20,dive,min=1,max=50,tag"`
 }
 
 // BulkUpdateServiceRequest represents a request to update multiple services
@@ -89,3 +207,141 @@ type BulkOperationResponse struct {
 	Count    int        `json:"count"`
 	Services []*Service `json:"services,omitempty"`
 }
+
+// BackupArchive represents a full backup produced by GET /backup and accepted by POST /restore.
+// Credentials (admin user, API keys) are not included.
+type BackupArchive struct {
+	Version            int                  `json:"version"`
+	CreatedAt          time.Time            `json:"createdAt"`
+	SecretsRedacted    bool                 `json:"secretsRedacted"` // Notification secrets were left out
+	Services           []*Service           `json:"services"`
+	Incidents          []*Incident          `json:"incidents"`
+	NotificationConfig *NotificationConfig  `json:"notificationConfig"`
+	MaintenanceWindows []*MaintenanceWindow `json:"maintenanceWindows"`
+	History            *BackupHistory       `json:"history,omitempty"` // Only included on request
+}
+
+// BackupHistory holds the check history of a backup
+type BackupHistory struct {
+	CheckResults []*CheckResult `json:"checkResults"`
+	Rollups      []*CheckRollup `json:"rollups"`
+}
+
+// RestoreResponse represents the response of a successful restore
+type RestoreResponse struct {
+	Success            bool `json:"success"`
+	Services           int  `json:"services"`
+	Incidents          int  `json:"incidents"`
+	MaintenanceWindows int  `json:"maintenanceWindows"`
+	CheckResults       int  `json:"checkResults"`
+	Rollups            int  `json:"rollups"`
+}
+
+// InventoryService represents a service in an export or import.
+// The YAML form matches the services list of the config file.
+type InventoryService struct {
+	ID       string   `json:"id,omitempty" yaml:"id,omitempty"`
+	Name     string   `json:"name" yaml:"name"`
+	URL      string   `json:"url" yaml:"url"`
+	Interval int      `json:"interval" yaml:"interval"`
+	Tags     []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+// ServiceInventory represents an exported or imported list of services
+type ServiceInventory struct {
+	Services []InventoryService `json:"services" yaml:"services"`
+}
+
+// ImportItem describes what an import does with one entry
+type ImportItem struct {
+	Index     int    `json:"index"`        // Position of the entry (or monitor/target) in the import, starting at 1
+	ID        string `json:"id,omitempty"` // Matched or created service
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	MatchedBy string `json:"matchedBy,omitempty"` // "id" or "url" for updates and unchanged entries
+	Reason    string `json:"reason,omitempty"`    // Why the entry conflicts or couldn't be translated
+	// Settings of the imported tool that Gjallarhorn doesn't check, such as keywords
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// ImportResponse represents the plan or result of an import
+type ImportResponse struct {
+	DryRun    bool         `json:"dryRun"`
+	Applied   bool         `json:"applied"`
+	Created   []ImportItem `json:"created"`
+	Updated   []ImportItem `json:"updated"`
+	Unchanged []ImportItem `json:"unchanged"`
+	Conflicts []ImportItem `json:"conflicts"`
+	Unmapped  []ImportItem `json:"unmapped"` // Monitors of other tools that can't be translated, such as TCP checks
+}
+
+// ReadinessResponse reports whether Gjallarhorn is working, check by check
+type ReadinessResponse struct {
+	Status string                    `json:"status"` // "ready" or "not ready"
+	Checks map[string]ReadinessCheck `json:"checks"` // "storage", "monitoring" and "notifications"
+}
+
+// ReadinessCheck is the result of one readiness check
+type ReadinessCheck struct {
+	Status string `json:"status"` // "ok" or "failing"
+	Error  string `json:"error,omitempty"`
+}
+
+// BadgeTokenResponse represents the response when a badge token is generated
+type BadgeTokenResponse struct {
+	Token string `json:"token"`
+}
+
+// AuthConfig holds the local admin credentials
+type AuthConfig struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"passwordHash"` // bcrypt hash
+}
+
+// LoginRequest represents a login attempt
+type LoginRequest struct {
+	Username string `json:"username" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+// ChangePasswordRequest represents a request to change the admin password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" validate:"required"`
+	NewPassword     string `json:"newPassword" validate:"required,min=8,max=72"`
+}
+
+// AuthStatusResponse describes the authentication state of the current request
+type AuthStatusResponse struct {
+	Enabled       bool     `json:"enabled"`
+	Authenticated bool     `json:"authenticated"`
+	Username      string   `json:"username,omitempty"`
+	Method        string   `json:"method,omitempty"` // "session", "token" or "key"
+	Scopes        []string `json:"scopes,omitempty"`
+	LocalLogin    bool     `json:"localLogin"` // Whether username/password login is available
+	OIDC          bool     `json:"oidc"`       // Whether single sign-on is available
+}
+
+// APIKey represents a scoped API key. Only a SHA-256 hash of the key is stored.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`         // Leading characters of the key, to help identify it
+	Hash       string     `json:"hash,omitempty"` // Never returned by the API
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// CreateAPIKeyRequest represents the request to create an API key
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" validate:"required,min=1,max=100"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1,dive,oneof=read services:write notifications:admin"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// CreateAPIKeyResponse contains the new key, which is only shown once
+type CreateAPIKeyResponse struct {
+	*APIKey
+	Key string `json:"key"`
+}
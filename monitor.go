@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -32,21 +33,34 @@ func getCheckInterval() time.Duration {
 
 // MonitorService handles service monitoring operations
 type MonitorService struct {
-	services map[string]*Service
-	mu       sync.RWMutex
-	client   *http.Client
-	storage  *StorageService
+	services  map[string]*Service
+	incidents []*Incident
+	mu        sync.RWMutex
+	client    *http.Client
+	storage   Store
+	events    *EventBroker
+	// Shutdown
+	checksCtx    context.Context // Canceled to abandon running checks when shutdown runs out of time
+	cancelChecks context.CancelFunc
+	stopped      chan struct{} // Closed when the monitoring loop has returned
+	// Check history, written outside the lock so a slow store doesn't hold up checks and handlers
+	results        chan *CheckResult
+	resultsWritten chan struct{} // Closed when the results left at shutdown are written
+	// Readiness
+	checkInterval time.Duration
+	lastTick      atomic.Int64 // Unix nanoseconds of the last monitoring round, read without the lock
+	lastRound     atomic.Int64 // Unix nanoseconds when the last round of checks completed, for the watchdog
 }
 
-// NewMonitorService creates a new monitor service
-func NewMonitorService() *MonitorService {
+// newCheckClient creates the HTTP client used for checks
+func newCheckClient() *http.Client {
 	// Allow skipping TLS verification via env var (for self-signed certs)
 	skipTLSVerify := os.Getenv("SKIP_TLS_VERIFY") == "true"
 	if skipTLSVerify {
 		log.Println("Warning: TLS certificate verification is disabled (SKIP_TLS_VERIFY=true)")
 	}
 
-	client := &http.Client{
+	return &http.Client{
 		Timeout: 30 * time.Second,
 		Transport: &http.Transport{
 			TLSClientConfig: &tls.Config{
@@ -54,33 +68,40 @@ func NewMonitorService() *MonitorService {
 			},
 		},
 	}
+}
 
-	storage := NewStorageService()
+// NewMonitorService creates a new monitor service
+func NewMonitorService(storage Store) *MonitorService {
+	client := newCheckClient()
 
-	// Load services from storage
+	// Load services from storage. Starting empty would overwrite the
+	// stored services on the next save, so refuse to start instead.
 	services, err := storage.LoadServices()
 	if err != nil {
-		log.Printf("Warning: Failed to load services from storage: %v", err)
-		services = make(map[string]*Service)
+		log.Fatalf("Failed to load services from storage: %v", err)
 	}
 
-	return &MonitorService{
-		services: services,
-		client:   client,
-		storage:  storage,
+	// Load incident history used for uptime reporting
+	incidents, err := storage.LoadIncidents()
+	if err != nil {
+		log.Fatalf("Failed to load incidents from storage: %v", err)
 	}
-}
 
-// saveServices saves services to persistent storage
-func (m *MonitorService) saveServices() error {
-	m.mu.RLock()
-	services := make(map[string]*Service)
-	for k, v := range m.services {
-		services[k] = v
-	}
-	m.mu.RUnlock()
+	checksCtx, cancelChecks := context.WithCancel(context.Background())
 
-	return m.storage.SaveServices(services)
+	return &MonitorService{
+		services:       services,
+		incidents:      incidents,
+		client:         client,
+		storage:        storage,
+		events:         NewEventBroker(),
+		checksCtx:      checksCtx,
+		cancelChecks:   cancelChecks,
+		stopped:        make(chan struct{}),
+		results:        make(chan *CheckResult, checkResultBuffer),
+		resultsWritten: make(chan struct{}),
+		checkInterval:  getCheckInterval(),
+	}
 }
 
 // saveServicesLocked saves services assuming the lock is already held
@@ -136,6 +157,7 @@ func (m *MonitorService) CreateService(c echo.Context) error {
 		Name:        req.Name,
 		URL:         req.URL,
 		Interval:    req.Interval,
+		Tags:        req.Tags,
 		Status:      "unknown",
 		LastChecked: time.Time{},
 		CreatedAt:   time.Now(),
@@ -144,12 +166,13 @@ func (m *MonitorService) CreateService(c echo.Context) error {
 
 	m.mu.Lock()
 	m.services[service.ID] = service
-	m.mu.Unlock()
+	m.publishServiceEvent("service.created", service)
 
 	// Save to persistent storage
-	if err := m.saveServices(); err != nil {
-		log.Printf("Warning: Failed to save services to storage: %v", err)
+	if err := m.storage.SaveService(service); err != nil {
+		log.Printf("Warning: Failed to save service to storage: %v", err)
 	}
+	m.mu.Unlock()
 
 	return c.JSON(http.StatusCreated, service)
 }
@@ -165,6 +188,7 @@ func (m *MonitorService) CreateService(c echo.Context) error {
 // @Success 200 {object} Service
 // @Failure 400 {object} map[string]string
 // @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Router /services/{id} [put]
 func (m *MonitorService) UpdateService(c echo.Context) error {
 	id := c.Param("id")
@@ -187,17 +211,26 @@ func (m *MonitorService) UpdateService(c echo.Context) error {
 		m.mu.Unlock()
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "service not found"})
 	}
+	if service.Managed {
+		m.mu.Unlock()
+		return c.JSON(http.StatusConflict, map[string]string{"error": errManagedService})
+	}
 
 	service.Name = req.Name
 	service.URL = req.URL
 	service.Interval = req.Interval
+	if req.Tags != nil {
+		service.Tags = req.Tags
+	}
 	service.UpdatedAt = time.Now()
-	m.mu.Unlock()
+	relabelServiceMetrics(service)
+	m.publishServiceEvent("service.updated", service)
 
 	// Save to persistent storage
-	if err := m.saveServices(); err != nil {
-		log.Printf("Warning: Failed to save services to storage: %v", err)
+	if err := m.storage.SaveService(service); err != nil {
+		log.Printf("Warning: Failed to save service to storage: %v", err)
 	}
+	m.mu.Unlock()
 
 	return c.JSON(http.StatusOK, service)
 }
@@ -210,6 +243,7 @@ func (m *MonitorService) UpdateService(c echo.Context) error {
 // @Success 204
 // @Failure 400 {object} map[string]string
 // @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Router /services/{id} [delete]
 func (m *MonitorService) DeleteService(c echo.Context) error {
 	id := c.Param("id")
@@ -218,18 +252,28 @@ func (m *MonitorService) DeleteService(c echo.Context) error {
 	}
 
 	m.mu.Lock()
-	if _, exists := m.services[id]; !exists {
+	service, exists := m.services[id]
+	if !exists {
 		m.mu.Unlock()
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "service not found"})
 	}
+	if service.Managed {
+		m.mu.Unlock()
+		return c.JSON(http.StatusConflict, map[string]string{"error": errManagedService})
+	}
 
 	delete(m.services, id)
-	m.mu.Unlock()
+	m.dropIncidentsLocked(id)
+	m.events.Publish(Event{Type: "service.deleted", ServiceID: id})
 
-	// Save to persistent storage
-	if err := m.saveServices(); err != nil {
-		log.Printf("Warning: Failed to save services to storage: %v", err)
+	// Save to persistent storage while holding the lock, so the stored services
+	// never differ from the running ones when a reload reads them
+	if err := m.storage.DeleteService(id); err != nil {
+		log.Printf("Warning: Failed to delete service from storage: %v", err)
 	}
+	m.mu.Unlock()
+
+	deleteServiceMetrics(id)
 
 	return c.NoContent(http.StatusNoContent)
 }
@@ -259,9 +303,10 @@ func (m *MonitorService) GetServiceStatus(c echo.Context) error {
 	}
 
 	status := ServiceStatus{
-		ServiceID:   service.ID,
-		Status:      service.Status,
-		LastChecked: service.LastChecked,
+		ServiceID:    service.ID,
+		Status:       service.Status,
+		LastChecked:  service.LastChecked,
+		ResponseTime: service.LastResponseTime,
 	}
 
 	return c.JSON(http.StatusOK, status)
@@ -296,6 +341,7 @@ func (m *MonitorService) BulkCreateServices(c echo.Context) error {
 			Name:        svcReq.Name,
 			URL:         svcReq.URL,
 			Interval:    svcReq.Interval,
+			Tags:        svcReq.Tags,
 			Status:      "unknown",
 			LastChecked: time.Time{},
 			CreatedAt:   now,
@@ -322,6 +368,9 @@ func (m *MonitorService) BulkCreateServices(c echo.Context) error {
 			"error": "Failed to persist services: " + err.Error(),
 		})
 	}
+	for _, service := range newServices {
+		m.publishServiceEvent("service.created", service)
+	}
 	m.mu.Unlock()
 
 	return c.JSON(http.StatusCreated, BulkOperationResponse{
@@ -341,6 +390,7 @@ func (m *MonitorService) BulkCreateServices(c echo.Context) error {
 // @Success 200 {object} BulkOperationResponse
 // @Failure 400 {object} map[string]string
 // @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
 // @Router /services/bulk [put]
 func (m *MonitorService) BulkUpdateServices(c echo.Context) error {
 	var req BulkUpdateServiceRequest
@@ -354,10 +404,13 @@ func (m *MonitorService) BulkUpdateServices(c echo.Context) error {
 
 	// Validate all IDs exist first (read lock)
 	m.mu.RLock()
-	var missingIDs []string
+	var missingIDs, managedIDs []string
 	for _, svcReq := range req.Services {
-		if _, exists := m.services[svcReq.ID]; !exists {
+		service, exists := m.services[svcReq.ID]
+		if !exists {
 			missingIDs = append(missingIDs, svcReq.ID)
+		} else if service.Managed {
+			managedIDs = append(managedIDs, svcReq.ID)
 		}
 	}
 	m.mu.RUnlock()
@@ -368,6 +421,12 @@ func (m *MonitorService) BulkUpdateServices(c echo.Context) error {
 			"missing_ids": missingIDs,
 		})
 	}
+	if len(managedIDs) > 0 {
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"error":       "Some services are managed by the config file",
+			"managed_ids": managedIDs,
+		})
+	}
 
 	// Apply updates atomically - hold lock through save to prevent race with checkService
 	m.mu.Lock()
@@ -377,6 +436,7 @@ func (m *MonitorService) BulkUpdateServices(c echo.Context) error {
 		Name      string
 		URL       string
 		Interval  int
+		Tags      []string
 		UpdatedAt time.Time
 	}
 	originals := make(map[string]originalValues)
@@ -386,6 +446,7 @@ func (m *MonitorService) BulkUpdateServices(c echo.Context) error {
 			Name:      service.Name,
 			URL:       service.URL,
 			Interval:  service.Interval,
+			Tags:      service.Tags,
 			UpdatedAt: service.UpdatedAt,
 		}
 	}
@@ -398,6 +459,9 @@ func (m *MonitorService) BulkUpdateServices(c echo.Context) error {
 		service.Name = svcReq.Name
 		service.URL = svcReq.URL
 		service.Interval = svcReq.Interval
+		if svcReq.Tags != nil {
+			service.Tags = svcReq.Tags
+		}
 		service.UpdatedAt = now
 		updatedServices = append(updatedServices, service)
 	}
@@ -410,6 +474,7 @@ func (m *MonitorService) BulkUpdateServices(c echo.Context) error {
 			service.Name = orig.Name
 			service.URL = orig.URL
 			service.Interval = orig.Interval
+			service.Tags = orig.Tags
 			service.UpdatedAt = orig.UpdatedAt
 		}
 		m.mu.Unlock()
@@ -418,6 +483,10 @@ func (m *MonitorService) BulkUpdateServices(c echo.Context) error {
 			"error": "Failed to persist updates: " + err.Error(),
 		})
 	}
+	for _, service := range updatedServices {
+		relabelServiceMetrics(service)
+		m.publishServiceEvent("service.updated", service)
+	}
 	m.mu.Unlock()
 
 	return c.JSON(http.StatusOK, BulkOperationResponse{
@@ -437,6 +506,7 @@ func (m *MonitorService) BulkUpdateServices(c echo.Context) error {
 // @Success 200 {object} BulkOperationResponse
 // @Failure 400 {object} map[string]string
 // @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
 // @Router /services/bulk [delete]
 func (m *MonitorService) BulkDeleteServices(c echo.Context) error {
 	var req BulkDeleteServiceRequest
@@ -450,10 +520,13 @@ func (m *MonitorService) BulkDeleteServices(c echo.Context) error {
 
 	// Validate all IDs exist first
 	m.mu.RLock()
-	var missingIDs []string
+	var missingIDs, managedIDs []string
 	for _, id := range req.IDs {
-		if _, exists := m.services[id]; !exists {
+		service, exists := m.services[id]
+		if !exists {
 			missingIDs = append(missingIDs, id)
+		} else if service.Managed {
+			managedIDs = append(managedIDs, id)
 		}
 	}
 	m.mu.RUnlock()
@@ -464,6 +537,12 @@ func (m *MonitorService) BulkDeleteServices(c echo.Context) error {
 			"missing_ids": missingIDs,
 		})
 	}
+	if len(managedIDs) > 0 {
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"error":       "Some services are managed by the config file",
+			"managed_ids": managedIDs,
+		})
+	}
 
 	// Delete atomically - hold lock through save
 	m.mu.Lock()
@@ -486,8 +565,16 @@ func (m *MonitorService) BulkDeleteServices(c echo.Context) error {
 			"error": "Failed to persist deletions: " + err.Error(),
 		})
 	}
+	m.dropIncidentsLocked(req.IDs...)
+	for _, id := range req.IDs {
+		m.events.Publish(Event{Type: "service.deleted", ServiceID: id})
+	}
 	m.mu.Unlock()
 
+	for _, id := range req.IDs {
+		deleteServiceMetrics(id)
+	}
+
 	return c.JSON(http.StatusOK, BulkOperationResponse{
 		Success: true,
 		Count:   len(req.IDs),
@@ -495,10 +582,17 @@ func (m *MonitorService) BulkDeleteServices(c echo.Context) error {
 }
 
 // StartMonitoring starts the background monitoring process
-func (m *MonitorService) StartMonitoring(notificationService *NotificationService) {
-	checkInterval := getCheckInterval()
-	log.Printf("Starting health check monitoring with interval: %v", checkInterval)
-	healthTicker := time.NewTicker(checkInterval)
+func (m *MonitorService) StartMonitoring(ctx context.Context, notificationService *NotificationService) {
+	defer close(m.stopped)
+
+	// Checks are waited for before the loop returns, so nothing records a result after this
+	go m.writeCheckResults()
+	defer close(m.results)
+
+	log.Printf("Starting health check monitoring with interval: %v", m.checkInterval)
+	m.lastTick.Store(time.Now().UnixNano())
+	m.lastRound.Store(time.Now().UnixNano())
+	healthTicker := time.NewTicker(m.checkInterval)
 	defer healthTicker.Stop()
 
 	// Reminder check ticker (every hour)
@@ -507,19 +601,52 @@ func (m *MonitorService) StartMonitoring(notificationService *NotificationServic
 
 	for {
 		select {
+		case <-ctx.Done():
+			log.Println("Stopped health check monitoring")
+			return
 		case <-healthTicker.C:
-			m.checkAllServices(notificationService)
+			m.lastTick.Store(time.Now().UnixNano())
+			m.checkAllServices(ctx, notificationService)
 		case <-reminderTicker.C:
 			m.checkReminders(notificationService)
 		}
 	}
 }
 
+// Shutdown waits for the monitoring loop to finish its running checks and their results
+// to be recorded, then saves the services so the latest failure counts and check times
+// survive the restart. Checks still running when ctx expires are abandoned without
+// recording a result.
+func (m *MonitorService) Shutdown(ctx context.Context) error {
+	var abandoned error
+	select {
+	case <-m.stopped:
+	case <-ctx.Done():
+		m.cancelChecks()
+		<-m.stopped
+		abandoned = fmt.Errorf("abandoned running checks: %v", ctx.Err())
+	}
+
+	select {
+	case <-m.resultsWritten:
+	case <-ctx.Done():
+		abandoned = fmt.Errorf("abandoned recording check results: %v", ctx.Err())
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.saveServicesLocked(); err != nil {
+		return fmt.Errorf("failed to save services: %v", err)
+	}
+	return abandoned
+}
+
 // maxConcurrentChecks limits concurrent health check goroutines
 const maxConcurrentChecks = 10
 
-// checkAllServices checks all services for their health
-func (m *MonitorService) checkAllServices(notificationService *NotificationService) {
+// checkAllServices checks all services for their health. Once ctx is done, no more checks
+// are started, but running ones are waited for.
+func (m *MonitorService) checkAllServices(ctx context.Context, notificationService *NotificationService) {
 	m.mu.RLock()
 	services := make([]*Service, 0, len(m.services))
 	for _, service := range m.services {
@@ -532,8 +659,16 @@ func (m *MonitorService) checkAllServices(notificationService *NotificationServi
 	var wg sync.WaitGroup
 
 	for _, service := range services {
+		// Acquire semaphore
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
 		wg.Add(1)
-		sem <- struct{}{} // Acquire semaphore
 		go func(svc *Service) {
 			defer wg.Done()
 			defer func() { <-sem }() // Release semaphore
@@ -542,20 +677,47 @@ func (m *MonitorService) checkAllServices(notificationService *NotificationServi
 	}
 
 	wg.Wait()
+	m.lastRound.Store(time.Now().UnixNano())
 }
 
 // checkService performs a health check on a single service
 func (m *MonitorService) checkService(service *Service, notificationService *NotificationService) {
+	result := runCheck(m.checksCtx, m.client, service)
+
+	// A check canceled at shutdown says nothing about the service
+	if m.checksCtx.Err() != nil {
+		log.Printf("Abandoned check of %s (%s) at shutdown", service.Name, service.URL)
+		return
+	}
+
+	// Track certificate expiry for HTTPS services
+	if result.CertExpiresAt != nil {
+		m.recordCertificateExpiry(service, *result.CertExpiresAt)
+	}
+
+	m.updateServiceStatus(service, result.Status, result.ResponseTime, result.Err, notificationService)
+}
+
+// checkOutcome is the result of a single check
+type checkOutcome struct {
+	Status        string // "online" or "failed"
+	StatusCode    int    // 0 when no response was received
+	ResponseTime  int64  // in milliseconds
+	CertExpiresAt *time.Time
+	Err           error
+}
+
+// runCheck requests a service's URL once and decides whether it is healthy
+func runCheck(ctx context.Context, client *http.Client, service *Service) checkOutcome {
 	start := time.Now()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, "GET", service.URL, nil)
 	if err != nil {
 		log.Printf("Error creating request for %s (%s): %v", service.Name, service.URL, err)
-		m.updateServiceStatus(service, "failed", 0, err, notificationService)
-		return
+		return checkOutcome{Status: "failed", Err: err}
 	}
 
 	req.Header.Set("User-Agent", "Gjallarhorn/1.0")
@@ -566,16 +728,21 @@ func (m *MonitorService) checkService(service *Service, notificationService *Not
 		req.Header.Set("X-Plex-Client-Identifier", "gjallarhorn-monitor")
 	}
 
-	resp, err := m.client.Do(req)
+	resp, err := client.Do(req)
 	responseTime := time.Since(start).Milliseconds()
 
 	if err != nil {
 		log.Printf("Request failed for %s (%s): %v", service.Name, service.URL, err)
-		m.updateServiceStatus(service, "failed", responseTime, err, notificationService)
-		return
+		return checkOutcome{Status: "failed", ResponseTime: responseTime, Err: err}
 	}
 	defer resp.Body.Close()
 
+	result := checkOutcome{StatusCode: resp.StatusCode, ResponseTime: responseTime}
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		expiresAt := resp.TLS.PeerCertificates[0].NotAfter
+		result.CertExpiresAt = &expiresAt
+	}
+
 	// Log the response details for debugging
 	log.Printf("Service %s (%s): HTTP %d, Response time: %dms", service.Name, service.URL, resp.StatusCode, responseTime)
 
@@ -585,11 +752,23 @@ func (m *MonitorService) checkService(service *Service, notificationService *Not
 		if resp.StatusCode == 401 {
 			log.Printf("Service %s (%s): HTTP 401 (unauthorized) - marking as online", service.Name, service.URL)
 		}
-		m.updateServiceStatus(service, "online", responseTime, nil, notificationService)
+		result.Status = "online"
 	} else {
-		err := fmt.Errorf("HTTP %d", resp.StatusCode)
+		result.Err = fmt.Errorf("HTTP %d", resp.StatusCode)
 		log.Printf("Service %s (%s) failed with HTTP %d", service.Name, service.URL, resp.StatusCode)
-		m.updateServiceStatus(service, "failed", responseTime, err, notificationService)
+		result.Status = "failed"
+	}
+	return result
+}
+
+// recordCertificateExpiry stores the expiry time of a service's TLS certificate
+func (m *MonitorService) recordCertificateExpiry(service *Service, expiresAt time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The service may have been deleted or replaced, such as by a reload, while it was being checked
+	if current, exists := m.services[service.ID]; exists {
+		current.CertExpiresAt = &expiresAt
 	}
 }
 
@@ -597,9 +776,21 @@ func (m *MonitorService) checkService(service *Service, notificationService *Not
 func (m *MonitorService) updateServiceStatus(service *Service, status string, responseTime int64, err error, notificationService *NotificationService) {
 	m.mu.Lock()
 
+	// The service may have been deleted or replaced while it was being checked
+	current, exists := m.services[service.ID]
+	if !exists {
+		m.mu.Unlock()
+		return
+	}
+	service = current
+
+	recordCheck(service, status)
+
 	previousStatus := service.Status
 	service.LastChecked = time.Now()
-	statusChanged := false
+	service.LastResponseTime = responseTime
+	var changedIncident *Incident
+	var notify func(service *Service) // Queues the notification once the lock is released
 
 	// Handle different status updates
 	if status == "online" {
@@ -624,12 +815,16 @@ func (m *MonitorService) updateServiceStatus(service *Service, status string, re
 			}
 
 			// Send recovery notification
-			notificationService.SendRecoveryNotification(service, downtimeDuration)
+			notify = func(service *Service) {
+				notificationService.SendRecoveryNotification(service, downtimeDuration)
+			}
+
+			// Close the open incident for this service
+			changedIncident = m.resolveIncidentLocked(service.ID, service.LastChecked)
 
 			// Clear downtime tracking
 			service.WentOfflineAt = nil
 			service.LastReminderAt = nil
-			statusChanged = true
 		}
 
 		service.Status = "online"
@@ -651,8 +846,18 @@ func (m *MonitorService) updateServiceStatus(service *Service, status string, re
 				if err != nil {
 					errorMsg = err.Error()
 				}
-				notificationService.SendNotification(service, errorMsg)
-				statusChanged = true
+				notify = func(service *Service) {
+					notificationService.SendNotification(service, errorMsg)
+				}
+
+				// Open an incident for uptime reporting
+				changedIncident = &Incident{
+					ID:        uuid.New().String(),
+					ServiceID: service.ID,
+					StartedAt: now,
+					Error:     errorMsg,
+				}
+				m.incidents = append(m.incidents, changedIncident)
 			}
 			service.Status = "offline"
 		} else {
@@ -662,13 +867,102 @@ func (m *MonitorService) updateServiceStatus(service *Service, status string, re
 	}
 
 	// Persist status changes to survive restarts
-	if statusChanged {
-		if err := m.saveServicesLocked(); err != nil {
+	if previousStatus != service.Status || changedIncident != nil {
+		if err := m.storage.SaveService(service); err != nil {
 			log.Printf("Warning: Failed to persist status change for %s: %v", service.Name, err)
 		}
 	}
+	if changedIncident != nil {
+		if err := m.storage.SaveIncident(changedIncident); err != nil {
+			log.Printf("Warning: Failed to persist incident for %s: %v", service.Name, err)
+		}
+	}
+
+	// Record the check in the history
+	result := &CheckResult{
+		ServiceID:    service.ID,
+		Status:       status,
+		ResponseTime: responseTime,
+		Timestamp:    service.LastChecked,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	select {
+	case m.results <- result:
+	default:
+		log.Printf("Warning: Dropped check result for %s, %d results are waiting to be recorded", service.Name, checkResultBuffer)
+	}
+
+	// Push the check result (and any status transition) to live subscribers
+	snapshot := *service
+	checked := Event{
+		Type:      "service.checked",
+		ServiceID: service.ID,
+		Service:   &snapshot,
+	}
+	if err != nil {
+		checked.Error = err.Error()
+	}
+	m.events.Publish(checked)
+	if previousStatus != service.Status {
+		m.events.Publish(Event{
+			Type:           "service.status",
+			ServiceID:      service.ID,
+			Service:        &snapshot,
+			PreviousStatus: previousStatus,
+		})
+	}
 
 	m.mu.Unlock()
+
+	// Queuing writes the notification to storage, which must not hold up other checks
+	if notify != nil {
+		notify(&snapshot)
+	}
+}
+
+// checkResultBuffer is the number of check results that can wait to be recorded before
+// further ones are dropped
+const checkResultBuffer = 1000
+
+// writeCheckResults records check results in the history until the monitoring loop has
+// returned and the remaining results are written
+func (m *MonitorService) writeCheckResults() {
+	defer close(m.resultsWritten)
+	for result := range m.results {
+		if err := m.storage.SaveCheckResult(result); err != nil {
+			log.Printf("Warning: Failed to record check result for service %s: %v", result.ServiceID, err)
+		}
+	}
+}
+
+// dropIncidentsLocked forgets the incidents of deleted services, which storage deletes
+// along with them, so saving the incidents doesn't bring them back. It assumes the lock is held.
+func (m *MonitorService) dropIncidentsLocked(ids ...string) {
+	deleted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		deleted[id] = true
+	}
+	kept := make([]*Incident, 0, len(m.incidents))
+	for _, incident := range m.incidents {
+		if !deleted[incident.ServiceID] {
+			kept = append(kept, incident)
+		}
+	}
+	m.incidents = kept
+}
+
+// resolveIncidentLocked marks the open incident for a service as resolved and returns it, assuming the lock is held
+func (m *MonitorService) resolveIncidentLocked(serviceID string, resolvedAt time.Time) *Incident {
+	for i := len(m.incidents) - 1; i >= 0; i-- {
+		incident := m.incidents[i]
+		if incident.ServiceID == serviceID && incident.ResolvedAt == nil {
+			incident.ResolvedAt = &resolvedAt
+			return incident
+		}
+	}
+	return nil
 }
 
 // checkReminders checks for services that have been down for over an hour and sends reminder notifications
@@ -702,7 +996,6 @@ func (m *MonitorService) checkReminders(notificationService *NotificationService
 // sendReminderNotification sends a reminder notification for a service that's been down
 func (m *MonitorService) sendReminderNotification(service *Service, notificationService *NotificationService) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
 
 	// Update the last reminder time
 	now := time.Now()
@@ -724,6 +1017,9 @@ func (m *MonitorService) sendReminderNotification(service *Service, notification
 		}
 	}
 
-	// Send reminder notification
-	notificationService.SendReminderNotification(service, downtimeDuration)
+	snapshot := *service
+	m.mu.Unlock()
+
+	// Send reminder notification once the lock is released, as queuing writes to storage
+	notificationService.SendReminderNotification(&snapshot, downtimeDuration)
 }
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestDeleteServiceRemovesIncidents(t *testing.T) {
+	stores := []struct {
+		name     string
+		newStore func(t *testing.T) Store
+	}{
+		{"json", func(t *testing.T) Store { return NewFileStore(t.TempDir()) }},
+		{"sqlite", func(t *testing.T) Store {
+			return openTestSQLStore(t, dialectSQLite, filepath.Join(t.TempDir(), "gjallarhorn.db"))
+		}},
+	}
+	deletes := []struct {
+		name   string
+		delete func(m *MonitorService, c echo.Context) error
+		req    func() *http.Request
+		params bool
+	}{
+		{"single", (*MonitorService).DeleteService, func() *http.Request {
+			return httptest.NewRequest(http.MethodDelete, "/api/services/web", nil)
+		}, true},
+		{"bulk", (*MonitorService).BulkDeleteServices, func() *http.Request {
+			req := httptest.NewRequest(http.MethodDelete, "/api/services/bulk", strings.NewReader(`{"ids":["web"]}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			return req
+		}, false},
+	}
+
+	for _, st := range stores {
+		for _, del := range deletes {
+			t.Run(st.name+"/"+del.name, func(t *testing.T) {
+				store := st.newStore(t)
+				if err := store.SaveServices(map[string]*Service{
+					"web": {ID: "web", Name: "Web"},
+					"api": {ID: "api", Name: "API"},
+				}); err != nil {
+					t.Fatalf("SaveServices failed: %v", err)
+				}
+				for _, incident := range []*Incident{
+					{ID: "1", ServiceID: "web", StartedAt: testTime(-time.Hour)},
+					{ID: "2", ServiceID: "api", StartedAt: testTime(-time.Minute)},
+				} {
+					if err := store.SaveIncident(incident); err != nil {
+						t.Fatalf("SaveIncident failed: %v", err)
+					}
+				}
+
+				m := NewMonitorService(store)
+				e := echo.New()
+				e.Validator = newValidator()
+				rec := httptest.NewRecorder()
+				c := e.NewContext(del.req(), rec)
+				if del.params {
+					c.SetParamNames("id")
+					c.SetParamValues("web")
+				}
+				if err := del.delete(m, c); err != nil {
+					t.Fatalf("delete returned %v", err)
+				}
+				if rec.Code >= 300 {
+					t.Fatalf("delete status = %d: %s", rec.Code, rec.Body)
+				}
+
+				// Saving the running incidents, as pruning does, must not bring the deleted ones back
+				m.mu.Lock()
+				if len(m.incidents) != 1 || m.incidents[0].ServiceID != "api" {
+					t.Errorf("running incidents = %v, want only the incident of api", m.incidents)
+				}
+				err := store.SaveIncidents(m.incidents)
+				m.mu.Unlock()
+				if err != nil {
+					t.Fatalf("SaveIncidents failed: %v", err)
+				}
+
+				incidents, err := store.LoadIncidents()
+				if err != nil || len(incidents) != 1 || incidents[0].ServiceID != "api" {
+					t.Errorf("stored incidents = %v, %v; want only the incident of api", incidents, err)
+				}
+			})
+		}
+	}
+}
+
+func TestFileStoreSaveServicesRemovesIncidents(t *testing.T) {
+	store := NewFileStore(t.TempDir())
+	if err := store.SaveServices(map[string]*Service{"web": {ID: "web"}, "api": {ID: "api"}}); err != nil {
+		t.Fatalf("SaveServices failed: %v", err)
+	}
+	if err := store.SaveIncidents([]*Incident{
+		{ID: "1", ServiceID: "web", StartedAt: testTime(-time.Hour)},
+		{ID: "2", ServiceID: "api", StartedAt: testTime(-time.Minute)},
+	}); err != nil {
+		t.Fatalf("SaveIncidents failed: %v", err)
+	}
+
+	if err := store.SaveServices(map[string]*Service{"api": {ID: "api"}}); err != nil {
+		t.Fatalf("SaveServices failed: %v", err)
+	}
+
+	// Read the files again, as on the next start
+	incidents, err := NewFileStore(store.dataDir).LoadIncidents()
+	if err != nil || len(incidents) != 1 || incidents[0].ServiceID != "api" {
+		t.Errorf("incidents after removing web = %v, %v; want only the incident of api", incidents, err)
+	}
+}
+
+// lockCheckingStore records whether the monitor's lock was free whenever a notification is queued
+type lockCheckingStore struct {
+	Store
+	monitor      *MonitorService
+	queued       int
+	queuedLocked int
+}
+
+func (s *lockCheckingStore) SaveNotificationDelivery(delivery *NotificationDelivery) error {
+	s.queued++
+	if s.monitor.mu.TryLock() {
+		s.monitor.mu.Unlock()
+	} else {
+		s.queuedLocked++
+	}
+	return s.Store.SaveNotificationDelivery(delivery)
+}
+
+func TestNotificationsAreQueuedOutsideMonitorLock(t *testing.T) {
+	store := &lockCheckingStore{Store: NewFileStore(t.TempDir())}
+	if err := store.SaveServices(map[string]*Service{"web": {ID: "web", Name: "Web", Status: "online"}}); err != nil {
+		t.Fatalf("SaveServices failed: %v", err)
+	}
+	if err := store.SaveNotificationConfig(&NotificationConfig{UserKey: "user", AppToken: "token", Enabled: true}); err != nil {
+		t.Fatalf("SaveNotificationConfig failed: %v", err)
+	}
+	m := NewMonitorService(store)
+	store.monitor = m
+	notifications := NewNotificationService(store)
+
+	service := m.services["web"]
+	for i := 0; i < 3; i++ {
+		m.updateServiceStatus(service, "failed", 0, errors.New("timeout"), notifications)
+	}
+	offlineAt := time.Now().Add(-2 * time.Hour)
+	m.mu.Lock()
+	service.WentOfflineAt, service.LastReminderAt = &offlineAt, &offlineAt
+	m.mu.Unlock()
+	m.checkReminders(notifications)
+	m.updateServiceStatus(service, "online", 10, nil, notifications)
+
+	if store.queued != 3 {
+		t.Errorf("queued %d notifications, want down, reminder and recovery", store.queued)
+	}
+	if store.queuedLocked != 0 {
+		t.Errorf("%d notifications were queued while the monitor's lock was held", store.queuedLocked)
+	}
+}
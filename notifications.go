@@ -2,26 +2,47 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
-// NotificationService handles Pushover notifications
+// NotificationService handles Pushover and webhook notifications. Notifications are queued in
+// storage and delivered by background workers, so a slow Pushover never holds up checks.
 type NotificationService struct {
-	config  *NotificationConfig
-	storage *StorageService
-}
+	config   *NotificationConfig // Replaced as a whole, never modified in place
+	storage  Store
+	managed  bool         // Config is declared in the config file and read-only through the API
+	configMu sync.RWMutex // Guards config and managed, which the reload goroutine changes
+
+	// Delivery queue
+	queue      map[string]*NotificationDelivery // Pending deliveries by ID
+	inFlight   map[string]bool
+	queueMu    sync.Mutex
+	wake       chan struct{}
+	workersCtx context.Context
+	stop       context.CancelFunc
+	workers    sync.WaitGroup
 
-// NewNotificationService creates a new notification service
-func NewNotificationService() *NotificationService {
-	storage := NewStorageService()
+	// Channel health, from the attempts in the delivery log
+	health   map[string]*channelHealth
+	healthMu sync.Mutex
+}
 
+// NewNotificationService creates a new notification service and loads the deliveries
+// still pending from the previous run and the health of the channels
+func NewNotificationService(storage Store) *NotificationService {
 	// Try to load config from storage first
 	config, err := storage.LoadNotificationConfig()
 	if err != nil {
@@ -34,10 +55,31 @@ func NewNotificationService() *NotificationService {
 		}
 	}
 
-	return &NotificationService{
-		config:  config,
-		storage: storage,
+	queue := make(map[string]*NotificationDelivery)
+	deliveries, err := storage.LoadNotificationDeliveries()
+	if err != nil {
+		log.Printf("Warning: Failed to load pending notifications from storage: %v", err)
 	}
+	for _, delivery := range deliveries {
+		if delivery.Status == deliveryPending {
+			queue[delivery.ID] = delivery
+		}
+	}
+
+	workersCtx, stop := context.WithCancel(context.Background())
+
+	n := &NotificationService{
+		config:     config,
+		storage:    storage,
+		queue:      queue,
+		inFlight:   make(map[string]bool),
+		wake:       make(chan struct{}, 1),
+		workersCtx: workersCtx,
+		stop:       stop,
+		health:     make(map[string]*channelHealth),
+	}
+	n.loadChannelHealth()
+	return n
 }
 
 // UpdateConfig updates the notification configuration
@@ -47,19 +89,87 @@ func (n *NotificationService) UpdateConfig(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 
-	n.config = &req
+	if n.isManaged() {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "notification configuration is managed by the config file and can't be changed through the API"})
+	}
+	if req.WebhookURL != "" {
+		if err := validateWebhookURL(req.WebhookURL); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+
+	// Check new Pushover keys with Pushover, so wrong ones are caught now rather than during an outage
+	response := map[string]string{"message": "Notification configuration updated"}
+	current := n.currentConfig()
+	if req.configured(channelPushover) && (req.UserKey != current.UserKey || req.AppToken != current.AppToken) {
+		ctx, cancel := context.WithTimeout(c.Request().Context(), 10*time.Second)
+		err := validatePushoverKeys(ctx, req.UserKey, req.AppToken)
+		cancel()
+		var permanent permanentError
+		if errors.As(err, &permanent) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Pushover rejected the credentials: " + err.Error()})
+		}
+		if err != nil {
+			log.Printf("Warning: Failed to verify Pushover credentials: %v", err)
+			response["warning"] = "Saved, but the Pushover credentials could not be verified: " + err.Error()
+		}
+	}
+
+	// The config file may have taken over while Pushover was being asked
+	if !n.setConfigUnlessManaged(&req) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "notification configuration is managed by the config file and can't be changed through the API"})
+	}
 
 	// Save to persistent storage
 	if err := n.storage.SaveNotificationConfig(&req); err != nil {
 		log.Printf("Warning: Failed to save notification config to storage: %v", err)
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{"message": "Notification configuration updated"})
+	return c.JSON(http.StatusOK, response)
+}
+
+// currentConfig returns the current configuration. It is replaced rather than modified, so
+// callers keep one snapshot for everything they do instead of reading it again.
+func (n *NotificationService) currentConfig() *NotificationConfig {
+	n.configMu.RLock()
+	defer n.configMu.RUnlock()
+	return n.config
+}
+
+// setConfig replaces the configuration
+func (n *NotificationService) setConfig(config *NotificationConfig) {
+	n.configMu.Lock()
+	n.config = config
+	n.configMu.Unlock()
+}
+
+// setConfigUnlessManaged replaces the configuration unless the config file manages it
+func (n *NotificationService) setConfigUnlessManaged(config *NotificationConfig) bool {
+	n.configMu.Lock()
+	defer n.configMu.Unlock()
+	if n.managed {
+		return false
+	}
+	n.config = config
+	return true
+}
+
+// isManaged reports whether the configuration is declared in the config file
+func (n *NotificationService) isManaged() bool {
+	n.configMu.RLock()
+	defer n.configMu.RUnlock()
+	return n.managed
 }
 
-// SendNotification sends a Pushover notification
+// enabled reports whether notifications are enabled and a channel is configured
+func (n *NotificationService) enabled() bool {
+	config := n.currentConfig()
+	return config.Enabled && len(config.channels()) > 0
+}
+
+// SendNotification queues a notification that a service went down
 func (n *NotificationService) SendNotification(service *Service, errorMsg string) {
-	if !n.config.Enabled || n.config.UserKey == "" || n.config.AppToken == "" {
+	if !n.enabled() {
 		return
 	}
 
@@ -71,43 +181,44 @@ func (n *NotificationService) SendNotification(service *Service, errorMsg string
 		message += fmt.Sprintf("\nError: %s", errorMsg)
 	}
 
-	payload := map[string]string{
-		"token":    n.config.AppToken,
-		"user":     n.config.UserKey,
-		"title":    title,
-		"message":  message,
-		"sound":    "siren",
-		"priority": "1", // High priority
-	}
-
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		fmt.Printf("Error marshaling notification payload: %v\n", err)
-		return
-	}
+	n.enqueue(&NotificationDelivery{
+		Event:     "down",
+		ServiceID: service.ID,
+		Title:     title,
+		Message:   message,
+		Sound:     "siren",
+		Priority:  "1", // High priority
+	})
+}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Post("https://api.pushover.net/1/messages.json",
-		"application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		fmt.Printf("Error sending notification: %v\n", err)
-		return
+// setManagedConfig replaces the configuration with the one from the config file and makes it read-only
+func (n *NotificationService) setManagedConfig(config *NotificationConfig) error {
+	if err := n.storage.SaveNotificationConfig(config); err != nil {
+		return fmt.Errorf("failed to save notification config from config file: %v", err)
 	}
-	defer resp.Body.Close()
+	n.configMu.Lock()
+	n.config = config
+	n.managed = true
+	n.configMu.Unlock()
+	return nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("Pushover API error: %d\n", resp.StatusCode)
-	}
+// releaseManagedConfig makes the configuration editable through the API again once the
+// config file no longer declares it
+func (n *NotificationService) releaseManagedConfig() {
+	n.configMu.Lock()
+	n.managed = false
+	n.configMu.Unlock()
 }
 
 // GetConfig returns the current notification configuration
 func (n *NotificationService) GetConfig() *NotificationConfig {
-	return n.config
+	return n.currentConfig()
 }
 
-// SendReminderNotification sends a reminder notification for a service that's been down
+// SendReminderNotification queues a reminder for a service that's been down
 func (n *NotificationService) SendReminderNotification(service *Service, downtimeDuration string) {
-	if !n.config.Enabled || n.config.UserKey == "" || n.config.AppToken == "" {
+	if !n.enabled() {
 		return
 	}
 
@@ -115,74 +226,227 @@ func (n *NotificationService) SendReminderNotification(service *Service, downtim
 	message := fmt.Sprintf("Service %s (%s) has been offline for %s.\nLast checked: %s\n\nThis is a reminder notification.",
 		service.Name, service.URL, downtimeDuration, service.LastChecked.Format(time.RFC3339))
 
-	payload := map[string]string{
-		"token":    n.config.AppToken,
-		"user":     n.config.UserKey,
-		"title":    title,
-		"message":  message,
-		"sound":    "pushover", // Different sound for reminders
-		"priority": "0",        // Normal priority for reminders
+	n.enqueue(&NotificationDelivery{
+		Event:     "reminder",
+		ServiceID: service.ID,
+		Title:     title,
+		Message:   message,
+		Sound:     "pushover", // Different sound for reminders
+		Priority:  "0",        // Normal priority for reminders
+	})
+}
+
+// SendRecoveryNotification queues a notification that a service came back online
+func (n *NotificationService) SendRecoveryNotification(service *Service, downtimeDuration string) {
+	if !n.enabled() {
+		return
 	}
 
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		fmt.Printf("Error marshaling reminder notification payload: %v\n", err)
+	title := fmt.Sprintf("✅ Service Recovered: %s", service.Name)
+	message := fmt.Sprintf("Service %s (%s) is back online!\nLast checked: %s",
+		service.Name, service.URL, service.LastChecked.Format(time.RFC3339))
+
+	if downtimeDuration != "" {
+		message += fmt.Sprintf("\n\nTotal downtime: %s", downtimeDuration)
+	}
+
+	n.enqueue(&NotificationDelivery{
+		Event:     "recovery",
+		ServiceID: service.ID,
+		Title:     title,
+		Message:   message,
+		Sound:     "magic", // Different sound for recovery
+		Priority:  "0",     // Normal priority
+	})
+}
+
+// SendWatchdogAlert queues a critical notification on every channel when Gjallarhorn itself has stopped checking services
+func (n *NotificationService) SendWatchdogAlert(stalledFor time.Duration) {
+	if !n.enabled() {
 		return
 	}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Post("https://api.pushover.net/1/messages.json",
-		"application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		fmt.Printf("Error sending reminder notification: %v\n", err)
+	n.enqueueBroadcast(&NotificationDelivery{
+		Event: "watchdog",
+		Title: "🛑 Gjallarhorn Stopped Checking",
+		Message: fmt.Sprintf("No round of health checks has completed for %s. Service statuses are stale and outages will not be reported until monitoring recovers.",
+			stalledFor.Round(time.Second)),
+		Sound:    "siren",
+		Priority: "1",
+	})
+}
+
+// SendWatchdogRecovery queues a notification on every channel when checks complete again after a stall
+func (n *NotificationService) SendWatchdogRecovery(stalledFor time.Duration) {
+	if !n.enabled() {
 		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("Pushover API error for reminder: %d\n", resp.StatusCode)
+	n.enqueueBroadcast(&NotificationDelivery{
+		Event:    "watchdog_recovery",
+		Title:    "✅ Gjallarhorn Checking Again",
+		Message:  fmt.Sprintf("Health checks are completing again after a stall of %s.", stalledFor.Round(time.Second)),
+		Sound:    "magic",
+		Priority: "0",
+	})
+}
+
+// Notification channels, in the order they are tried
+const (
+	channelPushover = "pushover"
+	channelWebhook  = "webhook"
+)
+
+var notificationChannels = []string{channelPushover, channelWebhook}
+
+// channels returns the configured channels in the order they are tried
+func (c *NotificationConfig) channels() []string {
+	var channels []string
+	for _, channel := range notificationChannels {
+		if c.configured(channel) {
+			channels = append(channels, channel)
+		}
 	}
+	return channels
 }
 
-// SendRecoveryNotification sends a notification when a service comes back online
-func (n *NotificationService) SendRecoveryNotification(service *Service, downtimeDuration string) {
-	if !n.config.Enabled || n.config.UserKey == "" || n.config.AppToken == "" {
-		return
+// configured reports whether a channel has the settings it needs
+func (c *NotificationConfig) configured(channel string) bool {
+	switch channel {
+	case channelPushover:
+		return c.UserKey != "" && c.AppToken != ""
+	case channelWebhook:
+		return c.WebhookURL != ""
 	}
+	return false
+}
 
-	title := fmt.Sprintf("✅ Service Recovered: %s", service.Name)
-	message := fmt.Sprintf("Service %s (%s) is back online!\nLast checked: %s",
-		service.Name, service.URL, service.LastChecked.Format(time.RFC3339))
+// validateWebhookURL checks that a webhook URL is an absolute http or https URL
+func validateWebhookURL(webhookURL string) error {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("webhook URL must be an http or https URL")
+	}
+	return nil
+}
 
-	if downtimeDuration != "" {
-		message += fmt.Sprintf("\n\nTotal downtime: %s", downtimeDuration)
+// sendTo sends a notification through a channel with the given settings and returns the HTTP status and body of
+// the provider's response, or 0 when there was none. Errors that retrying won't fix, such
+// as rejected keys, are returned as permanentError.
+func sendTo(ctx context.Context, config *NotificationConfig, channel string, notification *NotificationDelivery) (int, []byte, error) {
+	switch channel {
+	case channelPushover:
+		return sendPushover(ctx, config, notification)
+	case channelWebhook:
+		return sendWebhook(ctx, config, notification)
+	}
+	return 0, nil, permanentError{fmt.Errorf("unknown notification channel '%s'", channel)}
+}
+
+// pushoverAPIURL is the base URL of the Pushover API
+var pushoverAPIURL = "https://api.pushover.net/1"
+
+// sendPushover sends a notification through the Pushover API
+func sendPushover(ctx context.Context, config *NotificationConfig, notification *NotificationDelivery) (int, []byte, error) {
+	if !config.configured(channelPushover) {
+		return 0, nil, permanentError{fmt.Errorf("Pushover user key and app token are not configured")}
+	}
+
+	payload := map[string]string{
+		"token":    config.AppToken,
+		"user":     config.UserKey,
+		"title":    notification.Title,
+		"message":  notification.Message,
+		"priority": notification.Priority,
+	}
+	if notification.Sound != "" {
+		payload["sound"] = notification.Sound
+	}
+
+	statusCode, body, err := postNotification(ctx, pushoverAPIURL+"/messages.json", payload)
+	if err != nil || statusCode == http.StatusOK {
+		return statusCode, body, err
+	}
+	return statusCode, body, pushoverError(statusCode, body)
+}
+
+// validatePushoverKeys asks Pushover whether a user key and app token are valid, without sending a message
+func validatePushoverKeys(ctx context.Context, userKey, appToken string) error {
+	statusCode, body, err := postNotification(ctx, pushoverAPIURL+"/users/validate.json", map[string]string{
+		"token": appToken,
+		"user":  userKey,
+	})
+	if err != nil || statusCode == http.StatusOK {
+		return err
+	}
+	return pushoverError(statusCode, body)
+}
+
+// pushoverError describes a rejected Pushover request. Pushover explains why, such as a
+// revoked token, in an errors list.
+func pushoverError(statusCode int, body []byte) error {
+	var response struct {
+		Errors []string `json:"errors"`
+	}
+	err := fmt.Errorf("Pushover API error: HTTP %d", statusCode)
+	if json.Unmarshal(body, &response) == nil && len(response.Errors) > 0 {
+		err = fmt.Errorf("Pushover API error: HTTP %d: %s", statusCode, strings.Join(response.Errors, "; "))
+	}
+	return permanentUnlessRetryable(statusCode, err)
+}
+
+// sendWebhook posts a notification as JSON to the webhook URL
+func sendWebhook(ctx context.Context, config *NotificationConfig, notification *NotificationDelivery) (int, []byte, error) {
+	if !config.configured(channelWebhook) {
+		return 0, nil, permanentError{fmt.Errorf("webhook URL is not configured")}
 	}
 
 	payload := map[string]string{
-		"token":    n.config.AppToken,
-		"user":     n.config.UserKey,
-		"title":    title,
-		"message":  message,
-		"sound":    "magic", // Different sound for recovery
-		"priority": "0",     // Normal priority
+		"event":     notification.Event,
+		"serviceId": notification.ServiceID,
+		"title":     notification.Title,
+		"message":   notification.Message,
+		"priority":  notification.Priority,
+		"timestamp": time.Now().Format(time.RFC3339),
 	}
 
+	statusCode, body, err := postNotification(ctx, config.WebhookURL, payload)
+	if err != nil || (statusCode >= 200 && statusCode < 300) {
+		return statusCode, body, err
+	}
+	return statusCode, body, permanentUnlessRetryable(statusCode, fmt.Errorf("webhook error: HTTP %d", statusCode))
+}
+
+// postNotification posts a JSON payload and returns the response status and up to 4 KB of its body
+func postNotification(ctx context.Context, target string, payload map[string]string) (int, []byte, error) {
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
-		fmt.Printf("Error marshaling recovery notification payload: %v\n", err)
-		return
+		return 0, nil, permanentError{fmt.Errorf("failed to marshal notification payload: %v", err)}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return 0, nil, permanentError{fmt.Errorf("failed to create notification request: %v", err)}
 	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Gjallarhorn/1.0")
 
 	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Post("https://api.pushover.net/1/messages.json",
-		"application/json", bytes.NewBuffer(jsonData))
+	resp, err := client.Do(req)
 	if err != nil {
-		fmt.Printf("Error sending recovery notification: %v\n", err)
-		return
+		return 0, nil, fmt.Errorf("failed to send notification: %v", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("Pushover API error for recovery: %d\n", resp.StatusCode)
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return resp.StatusCode, body, nil
+}
+
+// permanentUnlessRetryable marks errors for 4xx responses as permanent. Providers reject
+// invalid keys and messages with 4xx; only rate limits are worth retrying.
+func permanentUnlessRetryable(statusCode int, err error) error {
+	if statusCode >= 400 && statusCode < 500 && statusCode != http.StatusTooManyRequests {
+		return permanentError{err}
 	}
+	return err
 }
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/oauth2"
+)
+
+// oidcStateCookieName binds an in-flight login to the browser that started it
+const oidcStateCookieName = "gjallarhorn_oidc_state"
+
+// oidcLoginTimeout is how long a user has to complete a login at the provider
+const oidcLoginTimeout = 10 * time.Minute
+
+// maxPendingOIDCLogins caps the logins in progress, since anyone can start one; the oldest
+// is dropped beyond this
+const maxPendingOIDCLogins = 10000
+
+// oidcPendingLogin holds the PKCE verifier and nonce of a login in progress
+type oidcPendingLogin struct {
+	verifier  string
+	nonce     string
+	expiresAt time.Time
+}
+
+// OIDCAuth handles OpenID Connect single sign-on with the authorization code flow and PKCE
+type OIDCAuth struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+	groupsClaim  string
+	roles        *roleMapping
+
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+	oauth    *oauth2.Config
+	pending  map[string]*oidcPendingLogin
+	mu       sync.Mutex
+}
+
+// NewOIDCAuth creates the OIDC authenticator from environment variables.
+// It returns nil when OIDC_ISSUER is not set.
+func NewOIDCAuth() *OIDCAuth {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if clientID == "" || redirectURL == "" {
+		log.Println("Warning: OIDC_ISSUER is set but OIDC_CLIENT_ID or OIDC_REDIRECT_URL is missing, single sign-on is disabled")
+		return nil
+	}
+
+	scopes := []string{oidc.ScopeOpenID, "profile", "email", "groups"}
+	if scopesStr := os.Getenv("OIDC_SCOPES"); scopesStr != "" {
+		scopes = strings.Fields(strings.ReplaceAll(scopesStr, ",", " "))
+	}
+
+	groupsClaim := os.Getenv("OIDC_GROUPS_CLAIM")
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+
+	return &OIDCAuth{
+		issuer:       issuer,
+		clientID:     clientID,
+		clientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		redirectURL:  redirectURL,
+		scopes:       scopes,
+		groupsClaim:  groupsClaim,
+		roles:        newRoleMapping("OIDC_", ""),
+		pending:      make(map[string]*oidcPendingLogin),
+	}
+}
+
+// init discovers the provider on first use so an unreachable identity
+// provider doesn't prevent Gjallarhorn from starting
+func (o *OIDCAuth) init(ctx context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.provider != nil {
+		return nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, o.issuer)
+	if err != nil {
+		return fmt.Errorf("failed to discover OIDC provider %s: %v", o.issuer, err)
+	}
+
+	o.provider = provider
+	o.verifier = provider.Verifier(&oidc.Config{ClientID: o.clientID})
+	o.oauth = &oauth2.Config{
+		ClientID:     o.clientID,
+		ClientSecret: o.clientSecret,
+		RedirectURL:  o.redirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       o.scopes,
+	}
+	return nil
+}
+
+// claimStrings reads a claim that may be a string or a list of strings
+func claimStrings(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return splitList(value)
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if str, ok := item.(string); ok {
+				values = append(values, str)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// OIDCLogin starts a single sign-on login by redirecting to the identity provider
+// @Summary Start SSO login
+// @Description Redirects to the OpenID Connect provider using the authorization code flow with PKCE
+// @Tags Auth
+// @Success 302
+// @Failure 404 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Router /auth/oidc/login [get]
+func (a *AuthService) OIDCLogin(c echo.Context) error {
+	o := a.oidc
+	if o == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "single sign-on is not configured"})
+	}
+
+	if err := o.init(c.Request().Context()); err != nil {
+		log.Printf("Error: %v", err)
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": "identity provider is unavailable"})
+	}
+
+	state, err := generateToken(16)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to start login: " + err.Error()})
+	}
+	nonce, err := generateToken(16)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to start login: " + err.Error()})
+	}
+	verifier := oauth2.GenerateVerifier()
+	expiresAt := time.Now().Add(oidcLoginTimeout)
+
+	o.mu.Lock()
+	o.evictPendingLoginsLocked()
+	o.pending[state] = &oidcPendingLogin{verifier: verifier, nonce: nonce, expiresAt: expiresAt}
+	o.mu.Unlock()
+
+	c.SetCookie(&http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    state,
+		Path:     "/api/auth/oidc",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return c.Redirect(http.StatusFound, o.oauth.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)))
+}
+
+// evictPendingLoginsLocked drops the expired logins in progress, and the oldest one when
+// the rest would exceed maxPendingOIDCLogins, assuming the lock is held
+func (o *OIDCAuth) evictPendingLoginsLocked() {
+	var oldest string
+	for state, login := range o.pending {
+		if time.Now().After(login.expiresAt) {
+			delete(o.pending, state)
+			continue
+		}
+		if oldest == "" || login.expiresAt.Before(o.pending[oldest].expiresAt) {
+			oldest = state
+		}
+	}
+	if len(o.pending) >= maxPendingOIDCLogins {
+		delete(o.pending, oldest)
+	}
+}
+
+// OIDCCallback completes a single sign-on login and starts a session
+// @Summary Complete SSO login
+// @Description Handles the redirect from the OpenID Connect provider, maps group claims to a role and sets a session cookie
+// @Tags Auth
+// @Param code query string true "Authorization code"
+// @Param state query string true "Login state"
+// @Success 302
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /auth/oidc/callback [get]
+func (a *AuthService) OIDCCallback(c echo.Context) error {
+	o := a.oidc
+	if o == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "single sign-on is not configured"})
+	}
+
+	if errParam := c.QueryParam("error"); errParam != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "identity provider returned an error: " + errParam})
+	}
+
+	state := c.QueryParam("state")
+	cookie, err := c.Cookie(oidcStateCookieName)
+	if err != nil || state == "" || cookie.Value != state {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid login state"})
+	}
+
+	o.mu.Lock()
+	login, exists := o.pending[state]
+	delete(o.pending, state)
+	o.mu.Unlock()
+
+	if !exists || time.Now().After(login.expiresAt) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "login expired, please try again"})
+	}
+
+	ctx := c.Request().Context()
+	token, err := o.oauth.Exchange(ctx, c.QueryParam("code"), oauth2.VerifierOption(login.verifier))
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %v", err)
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "failed to exchange authorization code"})
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "identity provider did not return an ID token"})
+	}
+
+	idToken, err := o.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		log.Printf("OIDC ID token verification failed: %v", err)
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid ID token"})
+	}
+	if idToken.Nonce != login.nonce {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid ID token nonce"})
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid ID token claims"})
+	}
+
+	username := idToken.Subject
+	for _, claim := range []string{"preferred_username", "email", "name"} {
+		if value, ok := claims[claim].(string); ok && value != "" {
+			username = value
+			break
+		}
+	}
+
+	scopes := o.roles.scopesForGroups(claimStrings(claims, o.groupsClaim))
+	if len(scopes) == 0 {
+		log.Printf("OIDC login denied for %s: no group maps to a role", username)
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "your account is not authorized to use Gjallarhorn"})
+	}
+
+	sessionToken, err := a.startSession(username, scopes)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create session: " + err.Error()})
+	}
+	c.SetCookie(newSessionCookie(c, sessionToken, time.Now().Add(sessionTTL)))
+
+	// Clear the state cookie
+	c.SetCookie(&http.Cookie{
+		Name:    oidcStateCookieName,
+		Value:   "",
+		Path:    "/api/auth/oidc",
+		Expires: time.Unix(0, 0),
+	})
+
+	log.Printf("OIDC login for %s", username)
+	return c.Redirect(http.StatusFound, oidcPostLoginPath(o.redirectURL))
+}
+
+// oidcPostLoginPath returns the UI root on the same host as the redirect URL
+func oidcPostLoginPath(redirectURL string) string {
+	u, err := url.Parse(redirectURL)
+	if err != nil {
+		return "/"
+	}
+	return strings.TrimSuffix(u.Path, "/api/auth/oidc/callback") + "/"
+}
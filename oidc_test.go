@@ -0,0 +1,476 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const testOIDCClientID = "gjallarhorn"
+
+// mockOIDCProvider is a minimal OpenID Connect provider that issues signed ID tokens
+// through the authorization code flow and enforces PKCE
+type mockOIDCProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	codes  map[string]mockAuthorization
+	claims map[string]interface{} // Extra claims of the next ID token
+	nonce  string                 // Overrides the nonce of the next ID token when set
+}
+
+// mockAuthorization is an authorization code waiting to be exchanged
+type mockAuthorization struct {
+	challenge string
+	nonce     string
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	p := &mockOIDCProvider{key: key, codes: make(map[string]mockAuthorization)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/keys", p.keys)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *mockOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                p.server.URL,
+		"authorization_endpoint":                p.server.URL + "/authorize",
+		"token_endpoint":                        p.server.URL + "/token",
+		"jwks_uri":                              p.server.URL + "/keys",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (p *mockOIDCProvider) keys(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize logs the user in right away and redirects back with a code
+func (p *mockOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+
+	code, _ := generateToken(16)
+	p.mu.Lock()
+	p.codes[code] = mockAuthorization{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	p.mu.Unlock()
+
+	redirect, _ := url.Parse(query.Get("redirect_uri"))
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *mockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	p.mu.Lock()
+	authorization, ok := p.codes[r.Form.Get("code")]
+	delete(p.codes, r.Form.Get("code"))
+	claims, nonce := p.claims, p.nonce
+	p.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != authorization.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	if nonce == "" {
+		nonce = authorization.nonce
+	}
+	idToken := map[string]interface{}{
+		"iss":   p.server.URL,
+		"aud":   testOIDCClientID,
+		"sub":   "user-1",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": nonce,
+	}
+	for name, value := range claims {
+		idToken[name] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     p.sign(idToken),
+	})
+}
+
+// sign encodes claims as a JWT signed with RS256
+func (p *mockOIDCProvider) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// newTestOIDCAuthService creates an auth service using the mock provider for single sign-on
+func newTestOIDCAuthService(p *mockOIDCProvider) *AuthService {
+	return &AuthService{
+		oidc: &OIDCAuth{
+			issuer:      p.server.URL,
+			clientID:    testOIDCClientID,
+			redirectURL: "http://gjallarhorn.test/api/auth/oidc/callback",
+			scopes:      []string{"openid", "profile", "groups"},
+			groupsClaim: "groups",
+			roles: &roleMapping{groups: map[string][]string{
+				"admin":  {"ops"},
+				"editor": {"dev"},
+				"viewer": {"staff"},
+			}},
+			pending: make(map[string]*oidcPendingLogin),
+		},
+		sessions: make(map[string]*session),
+		failures: make(map[string]*loginFailures),
+	}
+}
+
+// startOIDCLogin runs the login handler and follows its redirect to the mock provider.
+// It returns the state cookie and the callback URL the provider redirected to.
+func startOIDCLogin(t *testing.T, a *AuthService) (*http.Cookie, *url.URL) {
+	t.Helper()
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	if err := a.OIDCLogin(e.NewContext(httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil), rec)); err != nil {
+		t.Fatalf("OIDCLogin returned %v", err)
+	}
+	if rec.Code != http.StatusFound {
+		t.Fatalf("OIDCLogin status = %d, want %d: %s", rec.Code, http.StatusFound, rec.Body)
+	}
+
+	var state *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oidcStateCookieName {
+			state = cookie
+		}
+	}
+	if state == nil {
+		t.Fatal("OIDCLogin set no state cookie")
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(rec.Header().Get(echo.HeaderLocation))
+	if err != nil {
+		t.Fatalf("authorize request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize status = %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid callback URL: %v", err)
+	}
+	return state, callback
+}
+
+// finishOIDCLogin runs the callback handler with a state cookie and callback query
+func finishOIDCLogin(t *testing.T, a *AuthService, state *http.Cookie, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?"+query, nil)
+	if state != nil {
+		req.AddCookie(state)
+	}
+	rec := httptest.NewRecorder()
+	if err := a.OIDCCallback(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatalf("OIDCCallback returned %v", err)
+	}
+	return rec
+}
+
+// sessionScopes returns the scopes of the session a response started
+func sessionScopes(t *testing.T, a *AuthService, rec *httptest.ResponseRecorder) []string {
+	t.Helper()
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == sessionCookieName && cookie.Value != "" {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			if sess, ok := a.sessions[cookie.Value]; ok {
+				return sess.scopes
+			}
+		}
+	}
+	return nil
+}
+
+func TestOIDCLoginMapsGroupsToRoles(t *testing.T) {
+	tests := []struct {
+		name       string
+		groups     interface{}
+		wantStatus int
+		wantScopes []string
+	}{
+		{"admin group", []string{"staff", "ops"}, http.StatusFound, roleScopes["admin"]},
+		{"editor group", []string{"dev"}, http.StatusFound, roleScopes["editor"]},
+		{"viewer group as a string", "staff", http.StatusFound, roleScopes["viewer"]},
+		{"unmapped group", []string{"guests"}, http.StatusForbidden, nil},
+		{"no groups", nil, http.StatusForbidden, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newMockOIDCProvider(t)
+			p.claims = map[string]interface{}{"preferred_username": "alice"}
+			if tt.groups != nil {
+				p.claims["groups"] = tt.groups
+			}
+			a := newTestOIDCAuthService(p)
+
+			state, callback := startOIDCLogin(t, a)
+			rec := finishOIDCLogin(t, a, state, callback.RawQuery)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("OIDCCallback status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if scopes := sessionScopes(t, a, rec); !reflect.DeepEqual(scopes, tt.wantScopes) {
+				t.Errorf("session scopes = %v, want %v", scopes, tt.wantScopes)
+			}
+			if tt.wantStatus == http.StatusFound && rec.Header().Get(echo.HeaderLocation) != "/" {
+				t.Errorf("redirect after login = %q, want \"/\"", rec.Header().Get(echo.HeaderLocation))
+			}
+		})
+	}
+}
+
+func TestOIDCLoginRequestsPKCEAndNonce(t *testing.T) {
+	p := newMockOIDCProvider(t)
+	a := newTestOIDCAuthService(p)
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	if err := a.OIDCLogin(e.NewContext(httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil), rec)); err != nil {
+		t.Fatalf("OIDCLogin returned %v", err)
+	}
+	location, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+	if err != nil {
+		t.Fatalf("invalid redirect: %v", err)
+	}
+
+	query := location.Query()
+	state := query.Get("state")
+	login, ok := a.oidc.pending[state]
+	if !ok {
+		t.Fatalf("no pending login for state %q", state)
+	}
+	challenge := sha256.Sum256([]byte(login.verifier))
+	if got, want := query.Get("code_challenge"), base64.RawURLEncoding.EncodeToString(challenge[:]); got != want {
+		t.Errorf("code_challenge = %q, want %q", got, want)
+	}
+	if query.Get("code_challenge_method") != "S256" {
+		t.Errorf("code_challenge_method = %q, want S256", query.Get("code_challenge_method"))
+	}
+	if query.Get("nonce") == "" || query.Get("nonce") != login.nonce {
+		t.Errorf("nonce = %q, want the pending login's %q", query.Get("nonce"), login.nonce)
+	}
+	if query.Get("client_id") != testOIDCClientID {
+		t.Errorf("client_id = %q, want %q", query.Get("client_id"), testOIDCClientID)
+	}
+}
+
+func TestOIDCCallbackRejectsInvalidLogins(t *testing.T) {
+	tests := []struct {
+		name      string
+		tamper    func(a *AuthService, p *mockOIDCProvider, state *http.Cookie, query url.Values) (*http.Cookie, url.Values)
+		wantError string
+	}{
+		{
+			name: "state not matching the cookie",
+			tamper: func(a *AuthService, p *mockOIDCProvider, state *http.Cookie, query url.Values) (*http.Cookie, url.Values) {
+				query.Set("state", "forged")
+				return state, query
+			},
+			wantError: "invalid login state",
+		},
+		{
+			name: "missing state cookie",
+			tamper: func(a *AuthService, p *mockOIDCProvider, state *http.Cookie, query url.Values) (*http.Cookie, url.Values) {
+				return nil, query
+			},
+			wantError: "invalid login state",
+		},
+		{
+			name: "unknown state",
+			tamper: func(a *AuthService, p *mockOIDCProvider, state *http.Cookie, query url.Values) (*http.Cookie, url.Values) {
+				forged := &http.Cookie{Name: oidcStateCookieName, Value: "forged"}
+				query.Set("state", "forged")
+				return forged, query
+			},
+			wantError: "login expired",
+		},
+		{
+			name: "expired login",
+			tamper: func(a *AuthService, p *mockOIDCProvider, state *http.Cookie, query url.Values) (*http.Cookie, url.Values) {
+				a.oidc.pending[state.Value].expiresAt = time.Now().Add(-time.Second)
+				return state, query
+			},
+			wantError: "login expired",
+		},
+		{
+			name: "wrong PKCE verifier",
+			tamper: func(a *AuthService, p *mockOIDCProvider, state *http.Cookie, query url.Values) (*http.Cookie, url.Values) {
+				a.oidc.pending[state.Value].verifier = "not-the-verifier-the-challenge-was-made-from-00000"
+				return state, query
+			},
+			wantError: "failed to exchange authorization code",
+		},
+		{
+			name: "wrong nonce",
+			tamper: func(a *AuthService, p *mockOIDCProvider, state *http.Cookie, query url.Values) (*http.Cookie, url.Values) {
+				p.nonce = "replayed"
+				return state, query
+			},
+			wantError: "invalid ID token nonce",
+		},
+		{
+			name: "provider error",
+			tamper: func(a *AuthService, p *mockOIDCProvider, state *http.Cookie, query url.Values) (*http.Cookie, url.Values) {
+				return state, url.Values{"error": {"access_denied"}, "state": {state.Value}}
+			},
+			wantError: "access_denied",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newMockOIDCProvider(t)
+			p.claims = map[string]interface{}{"groups": []string{"ops"}}
+			a := newTestOIDCAuthService(p)
+
+			state, callback := startOIDCLogin(t, a)
+			state, query := tt.tamper(a, p, state, callback.Query())
+			rec := finishOIDCLogin(t, a, state, query.Encode())
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("OIDCCallback status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantError) {
+				t.Errorf("OIDCCallback error = %s, want %q", rec.Body, tt.wantError)
+			}
+			if scopes := sessionScopes(t, a, rec); scopes != nil {
+				t.Errorf("rejected login started a session with scopes %v", scopes)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRejectsReplayedState(t *testing.T) {
+	p := newMockOIDCProvider(t)
+	p.claims = map[string]interface{}{"groups": []string{"ops"}}
+	a := newTestOIDCAuthService(p)
+
+	state, callback := startOIDCLogin(t, a)
+	if rec := finishOIDCLogin(t, a, state, callback.RawQuery); rec.Code != http.StatusFound {
+		t.Fatalf("first callback status = %d, want %d: %s", rec.Code, http.StatusFound, rec.Body)
+	}
+	rec := finishOIDCLogin(t, a, state, callback.RawQuery)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("replayed callback status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestOIDCLoginCapsPendingLogins(t *testing.T) {
+	p := newMockOIDCProvider(t)
+	p.claims = map[string]interface{}{"groups": []string{"ops"}}
+	a := newTestOIDCAuthService(p)
+
+	now := time.Now()
+	a.oidc.pending["expired"] = &oidcPendingLogin{expiresAt: now.Add(-time.Second)}
+	for i := 0; len(a.oidc.pending) <= maxPendingOIDCLogins; i++ {
+		a.oidc.pending[fmt.Sprintf("state-%d", i)] = &oidcPendingLogin{expiresAt: now.Add(time.Minute + time.Duration(i)*time.Millisecond)}
+	}
+
+	state, callback := startOIDCLogin(t, a)
+	if got := len(a.oidc.pending); got != maxPendingOIDCLogins {
+		t.Errorf("pending logins = %d, want %d", got, maxPendingOIDCLogins)
+	}
+	for _, dropped := range []string{"expired", "state-0"} {
+		if _, exists := a.oidc.pending[dropped]; exists {
+			t.Errorf("pending login %s was kept", dropped)
+		}
+	}
+	if rec := finishOIDCLogin(t, a, state, callback.RawQuery); rec.Code != http.StatusFound {
+		t.Errorf("callback status = %d, want %d: %s", rec.Code, http.StatusFound, rec.Body)
+	}
+}
+
+func TestClaimStrings(t *testing.T) {
+	claims := map[string]interface{}{
+		"list":   []interface{}{"ops", 7, "dev"},
+		"string": "ops, dev",
+		"number": 7,
+	}
+	tests := []struct {
+		claim string
+		want  []string
+	}{
+		{"list", []string{"ops", "dev"}},
+		{"string", []string{"ops", "dev"}},
+		{"number", nil},
+		{"missing", nil},
+	}
+	for _, tt := range tests {
+		if got := claimStrings(claims, tt.claim); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("claimStrings(%q) = %v, want %v", tt.claim, got, tt.want)
+		}
+	}
+}
+
+func TestOIDCPostLoginPath(t *testing.T) {
+	tests := map[string]string{
+		"https://status.example.com/api/auth/oidc/callback":      "/",
+		"https://example.com/gjallarhorn/api/auth/oidc/callback": "/gjallarhorn/",
+		"%zz": "/",
+	}
+	for redirectURL, want := range tests {
+		if got := oidcPostLoginPath(redirectURL); got != want {
+			t.Errorf("oidcPostLoginPath(%q) = %q, want %q", redirectURL, got, want)
+		}
+	}
+}
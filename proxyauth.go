@@ -0,0 +1,131 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ProxyAuth trusts a user header set by an authenticating reverse proxy such as
+// Authelia, oauth2-proxy or Traefik forward auth
+type ProxyAuth struct {
+	header       string
+	groupsHeader string
+	trusted      []*net.IPNet
+	roles        *roleMapping
+}
+
+// NewProxyAuth creates the reverse proxy authenticator from environment variables.
+// It returns nil when AUTH_PROXY_HEADER or TRUSTED_PROXIES is not set.
+func NewProxyAuth() *ProxyAuth {
+	header := os.Getenv("AUTH_PROXY_HEADER")
+	if header == "" {
+		return nil
+	}
+
+	trusted := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if len(trusted) == 0 {
+		log.Println("Warning: AUTH_PROXY_HEADER is set but TRUSTED_PROXIES is empty, proxy authentication is disabled")
+		return nil
+	}
+
+	return &ProxyAuth{
+		header:       header,
+		groupsHeader: os.Getenv("AUTH_PROXY_GROUPS_HEADER"),
+		trusted:      trusted,
+		roles:        newRoleMapping("AUTH_PROXY_", "admin"),
+	}
+}
+
+// parseTrustedProxies parses a comma-separated list of CIDRs or bare IP addresses
+func parseTrustedProxies(value string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range splitList(value) {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				log.Printf("Warning: Ignoring invalid trusted proxy '%s'", entry)
+				continue
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Warning: Ignoring invalid trusted proxy '%s': %v", entry, err)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// trustsPeer reports whether the direct peer of a request is a trusted proxy.
+// The peer address is used rather than X-Forwarded-For, which clients can forge.
+func (p *ProxyAuth) trustsPeer(c echo.Context) bool {
+	host, _, err := net.SplitHostPort(c.Request().RemoteAddr)
+	if err != nil {
+		host = c.Request().RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range p.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate resolves the principal from the proxy's user header.
+// It returns nil when the header is absent or the request didn't come through a trusted proxy.
+func (p *ProxyAuth) authenticate(c echo.Context) *Principal {
+	username := strings.TrimSpace(c.Request().Header.Get(p.header))
+	if username == "" {
+		return nil
+	}
+
+	if !p.trustsPeer(c) {
+		log.Printf("Warning: Ignoring %s header from untrusted address %s", p.header, c.Request().RemoteAddr)
+		return nil
+	}
+
+	var groups []string
+	if p.groupsHeader != "" {
+		// Proxies separate groups with commas (Authelia, oauth2-proxy) or pipes (Authentik)
+		groups = splitList(strings.ReplaceAll(c.Request().Header.Get(p.groupsHeader), "|", ","))
+	}
+
+	scopes := p.roles.scopesForGroups(groups)
+	if len(scopes) == 0 {
+		return nil
+	}
+
+	return &Principal{Name: username, Method: "proxy", Scopes: scopes}
+}
+
+// newTrustedProxyIPExtractor makes Echo's RealIP honour X-Forwarded-For only
+// when the request comes from one of the trusted proxies
+func newTrustedProxyIPExtractor(trusted []*net.IPNet) echo.IPExtractor {
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, network := range trusted {
+		options = append(options, echo.TrustIPRange(network))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// Delivery statuses
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+)
+
+const (
+	maxDeliveryAttempts   = 10               // Attempts before a delivery is given up as failed
+	deliveryRetryBase     = 30 * time.Second // Wait before the first retry; doubled for each further one
+	deliveryRetryMax      = time.Hour
+	deliveryRetention     = 7 * 24 * time.Hour // Finished deliveries and attempts are kept this long
+	deliveryPruneInterval = time.Hour
+)
+
+// permanentError is a delivery error that retrying won't fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// getNotificationWorkers returns the number of delivery workers from env or default 2
+func getNotificationWorkers() int {
+	workersStr := os.Getenv("NOTIFICATION_WORKERS")
+	if workersStr == "" {
+		return 2
+	}
+	workers, err := strconv.Atoi(workersStr)
+	if err != nil || workers < 1 {
+		log.Printf("Invalid NOTIFICATION_WORKERS '%s', using default 2", workersStr)
+		return 2
+	}
+	return workers
+}
+
+// deliveryBackoff returns how long to wait before retrying after a number of attempts
+func deliveryBackoff(attempts int) time.Duration {
+	backoff := deliveryRetryBase
+	for i := 1; i < attempts && backoff < deliveryRetryMax; i++ {
+		backoff *= 2
+	}
+	if backoff > deliveryRetryMax {
+		return deliveryRetryMax
+	}
+	return backoff
+}
+
+// enqueue stores a notification and wakes a worker to deliver it. A notification that
+// can't be stored is still delivered, but is lost if Gjallarhorn restarts first.
+func (n *NotificationService) enqueue(delivery *NotificationDelivery) {
+	// The channels may have been removed since the caller checked
+	channels := n.currentConfig().channels()
+	if len(channels) == 0 {
+		log.Printf("Warning: Dropping %s notification, no notification channel is configured", delivery.Event)
+		return
+	}
+	n.enqueueTo(channels[0], delivery)
+}
+
+// enqueueBroadcast queues a copy of a notification for every configured channel, for alerts
+// that must not wait for a failing channel to be given up on
+func (n *NotificationService) enqueueBroadcast(delivery *NotificationDelivery) {
+	channels := n.currentConfig().channels()
+	if len(channels) == 0 {
+		log.Printf("Warning: Dropping %s notification, no notification channel is configured", delivery.Event)
+		return
+	}
+	for _, channel := range channels {
+		broadcast := *delivery
+		broadcast.Broadcast = true
+		n.enqueueTo(channel, &broadcast)
+	}
+}
+
+// enqueueTo stores a notification that is first tried through a channel and wakes a worker
+func (n *NotificationService) enqueueTo(channel string, delivery *NotificationDelivery) {
+	now := time.Now()
+	delivery.ID = uuid.New().String()
+	delivery.Channel = channel
+	delivery.Status = deliveryPending
+	delivery.CreatedAt = now
+	delivery.NextAttemptAt = now
+
+	if err := n.storage.SaveNotificationDelivery(delivery); err != nil {
+		log.Printf("Warning: Failed to save queued notification: %v", err)
+	}
+
+	n.queueMu.Lock()
+	n.queue[delivery.ID] = delivery
+	n.queueMu.Unlock()
+	n.wakeWorker()
+}
+
+// wakeWorker lets an idle worker look at the queue again
+func (n *NotificationService) wakeWorker() {
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// StartDelivery starts the delivery workers and the pruning of finished deliveries.
+// They run until Shutdown.
+func (n *NotificationService) StartDelivery() {
+	workers := getNotificationWorkers()
+	n.queueMu.Lock()
+	pending := len(n.queue)
+	n.queueMu.Unlock()
+	log.Printf("Starting %d notification delivery workers, %d notifications pending", workers, pending)
+
+	n.workers.Add(workers + 1)
+	for i := 0; i < workers; i++ {
+		go n.deliveryWorker()
+	}
+	go n.pruneDeliveries()
+}
+
+// deliveryWorker delivers due notifications until the workers are stopped
+func (n *NotificationService) deliveryWorker() {
+	defer n.workers.Done()
+
+	for {
+		delivery, wait := n.nextDelivery(time.Now())
+		if delivery != nil {
+			n.deliver(delivery)
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-n.workersCtx.Done():
+			timer.Stop()
+			return
+		case <-n.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// blockedLocked returns the pending deliveries that wait for an older delivery of the same
+// service, so a recovery is never sent before the outage it resolves. Assumes queueMu is held.
+func (n *NotificationService) blockedLocked() map[string]bool {
+	oldest := make(map[string]*NotificationDelivery)
+	for _, delivery := range n.queue {
+		if delivery.ServiceID == "" {
+			continue
+		}
+		current, ok := oldest[delivery.ServiceID]
+		if !ok || delivery.CreatedAt.Before(current.CreatedAt) ||
+			(delivery.CreatedAt.Equal(current.CreatedAt) && delivery.ID < current.ID) {
+			oldest[delivery.ServiceID] = delivery
+		}
+	}
+
+	blocked := make(map[string]bool)
+	for id, delivery := range n.queue {
+		if delivery.ServiceID != "" && oldest[delivery.ServiceID].ID != id {
+			blocked[id] = true
+		}
+	}
+	return blocked
+}
+
+// nextDelivery claims the delivery that has been due the longest. When none is due, it
+// returns how long until one is.
+func (n *NotificationService) nextDelivery(now time.Time) (*NotificationDelivery, time.Duration) {
+	n.queueMu.Lock()
+	defer n.queueMu.Unlock()
+
+	blocked := n.blockedLocked()
+	var next *NotificationDelivery
+	for id, delivery := range n.queue {
+		if n.inFlight[id] || blocked[id] {
+			continue
+		}
+		if next == nil || delivery.NextAttemptAt.Before(next.NextAttemptAt) {
+			next = delivery
+		}
+	}
+
+	if next == nil {
+		return nil, time.Minute // Enqueuing and finished deliveries wake the workers
+	}
+	if wait := next.NextAttemptAt.Sub(now); wait > 0 {
+		return nil, wait
+	}
+	n.inFlight[next.ID] = true
+	claimed := *next
+	return &claimed, 0
+}
+
+// deliver makes one attempt to deliver a notification and records the outcome
+func (n *NotificationService) deliver(delivery *NotificationDelivery) {
+	err := n.deliverThroughChannels(delivery)
+
+	now := time.Now()
+	var permanent permanentError
+	switch {
+	case err == nil:
+		delivery.Attempts++
+		delivery.Status = deliveryDelivered
+		delivery.DeliveredAt = &now
+		delivery.LastError = ""
+	case n.workersCtx.Err() != nil:
+		// Interrupted by shutdown; the attempt is repeated after the restart
+	case errors.As(err, &permanent) || delivery.Attempts+1 >= maxDeliveryAttempts:
+		delivery.Attempts++
+		delivery.Status = deliveryFailed
+		delivery.LastError = err.Error()
+		log.Printf("Error: Giving up on %s notification %s after %d attempts: %v", delivery.Event, delivery.ID, delivery.Attempts, err)
+	default:
+		delivery.Attempts++
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = now.Add(deliveryBackoff(delivery.Attempts))
+		log.Printf("Warning: Failed to deliver %s notification %s (attempt %d), retrying at %s: %v",
+			delivery.Event, delivery.ID, delivery.Attempts, delivery.NextAttemptAt.Format(time.RFC3339), err)
+	}
+
+	if err := n.storage.SaveNotificationDelivery(delivery); err != nil {
+		log.Printf("Warning: Failed to save notification delivery: %v", err)
+	}
+
+	n.queueMu.Lock()
+	delete(n.inFlight, delivery.ID)
+	if delivery.Status == deliveryPending {
+		n.queue[delivery.ID] = delivery
+	} else {
+		delete(n.queue, delivery.ID)
+	}
+	n.queueMu.Unlock()
+
+	// Deliveries of the same service may have been waiting for this one
+	n.wakeWorker()
+}
+
+// pruneDeliveries periodically removes finished deliveries and attempts past their retention until the workers are stopped
+func (n *NotificationService) pruneDeliveries() {
+	defer n.workers.Done()
+
+	ticker := time.NewTicker(deliveryPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		before := time.Now().Add(-deliveryRetention)
+		if err := n.storage.PruneNotificationDeliveries(before); err != nil {
+			log.Printf("Warning: Failed to prune notification deliveries: %v", err)
+		}
+		if err := n.storage.PruneNotificationAttempts(before); err != nil {
+			log.Printf("Warning: Failed to prune notification attempts: %v", err)
+		}
+		select {
+		case <-n.workersCtx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// overdueDeliveries returns the number of deliveries a worker should have picked up by now,
+// and when the longest waiting one became due
+func (n *NotificationService) overdueDeliveries(now time.Time) (int, time.Time) {
+	n.queueMu.Lock()
+	defer n.queueMu.Unlock()
+
+	blocked := n.blockedLocked()
+	count := 0
+	var oldest time.Time
+	for id, delivery := range n.queue {
+		if n.inFlight[id] || blocked[id] || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		count++
+		if oldest.IsZero() || delivery.NextAttemptAt.Before(oldest) {
+			oldest = delivery.NextAttemptAt
+		}
+	}
+	return count, oldest
+}
+
+// Shutdown waits until the notifications that are due have been attempted, then stops the
+// workers. Deliveries still pending stay queued in storage for the next start.
+func (n *NotificationService) Shutdown(ctx context.Context) error {
+	defer n.workers.Wait()
+	defer n.stop()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		n.queueMu.Lock()
+		busy := len(n.inFlight) > 0
+		n.queueMu.Unlock()
+		if due, _ := n.overdueDeliveries(time.Now()); !busy && due == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("notifications still being delivered: %v", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// ListDeliveries returns queued and recent notification deliveries
+// @Summary List notification deliveries
+// @Description Returns queued notifications and those delivered or given up in the last 7 days, newest first. Failed attempts are retried with exponential backoff, up to 10 attempts.
+// @Tags Notifications
+// @Produce json
+// @Param status query string false "Only deliveries with this status" Enums(pending, delivered, failed)
+// @Param limit query int false "Maximum number of deliveries" default(100)
+// @Success 200 {array} NotificationDelivery
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /notifications/deliveries [get]
+func (n *NotificationService) ListDeliveries(c echo.Context) error {
+	status := c.QueryParam("status")
+	switch status {
+	case "", deliveryPending, deliveryDelivered, deliveryFailed:
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "status must be pending, delivered or failed"})
+	}
+
+	limit := 100
+	if limitStr := c.QueryParam("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and 1000"})
+		}
+		limit = parsed
+	}
+
+	deliveries, err := n.storage.LoadNotificationDeliveries()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load notification deliveries: " + err.Error()})
+	}
+
+	result := make([]*NotificationDelivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		if status == "" || delivery.Status == status {
+			result = append(result, delivery)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.After(result[j].CreatedAt) })
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// LoadNotificationDeliveries loads all stored notification deliveries
+func (s *FileStore) LoadNotificationDeliveries() ([]*NotificationDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.deliveries == nil {
+		deliveries, err := s.readDeliveriesFile()
+		if err != nil {
+			return nil, err
+		}
+		s.deliveries = deliveries
+	}
+
+	deliveries := make([]*NotificationDelivery, 0, len(s.deliveries))
+	for _, delivery := range s.deliveries {
+		snapshot := *delivery
+		deliveries = append(deliveries, &snapshot)
+	}
+	return deliveries, nil
+}
+
+// SaveNotificationDelivery creates or updates a single stored delivery
+func (s *FileStore) SaveNotificationDelivery(delivery *NotificationDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.deliveries == nil {
+		deliveries, err := s.readDeliveriesFile()
+		if err != nil {
+			return err
+		}
+		s.deliveries = deliveries
+	}
+
+	snapshot := *delivery
+	for i, existing := range s.deliveries {
+		if existing.ID == delivery.ID {
+			s.deliveries[i] = &snapshot
+			return s.writeDeliveriesLocked()
+		}
+	}
+	s.deliveries = append(s.deliveries, &snapshot)
+	return s.writeDeliveriesLocked()
+}
+
+// PruneNotificationDeliveries removes delivered and failed deliveries created before a time
+func (s *FileStore) PruneNotificationDeliveries(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.deliveries == nil {
+		deliveries, err := s.readDeliveriesFile()
+		if err != nil {
+			return err
+		}
+		s.deliveries = deliveries
+	}
+
+	kept := make([]*NotificationDelivery, 0, len(s.deliveries))
+	for _, delivery := range s.deliveries {
+		if delivery.Status == deliveryPending || !delivery.CreatedAt.Before(before) {
+			kept = append(kept, delivery)
+		}
+	}
+	if len(kept) == len(s.deliveries) {
+		return nil
+	}
+	s.deliveries = kept
+	return s.writeDeliveriesLocked()
+}
+
+// writeDeliveriesLocked writes the cached deliveries to the deliveries file, assuming the lock is held
+func (s *FileStore) writeDeliveriesLocked() error {
+	if err := s.ensureDataDir(); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(s.deliveries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification deliveries: %v", err)
+	}
+
+	if err := writeFileAtomic(s.deliveriesFile, data); err != nil {
+		return fmt.Errorf("failed to write notification deliveries file: %v", err)
+	}
+
+	return nil
+}
+
+// readDeliveriesFile reads the deliveries file
+func (s *FileStore) readDeliveriesFile() ([]*NotificationDelivery, error) {
+	if _, err := os.Stat(s.deliveriesFile); os.IsNotExist(err) {
+		return []*NotificationDelivery{}, nil
+	}
+
+	data, err := readFileWithFallback(s.deliveriesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notification deliveries file: %v", err)
+	}
+
+	var deliveries []*NotificationDelivery
+	if err := json.Unmarshal(data, &deliveries); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal notification deliveries: %v", err)
+	}
+
+	if deliveries == nil {
+		deliveries = []*NotificationDelivery{}
+	}
+
+	return deliveries, nil
+}
+
+// LoadNotificationDeliveries loads all stored notification deliveries, oldest first
+func (s *SQLStore) LoadNotificationDeliveries() ([]*NotificationDelivery, error) {
+	rows, err := s.db.Query("SELECT data FROM notification_deliveries ORDER BY created_at")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notification deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	deliveries := []*NotificationDelivery{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to read notification delivery: %v", err)
+		}
+		var delivery NotificationDelivery
+		if err := json.Unmarshal([]byte(data), &delivery); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal notification delivery: %v", err)
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read notification deliveries: %v", err)
+	}
+
+	return deliveries, nil
+}
+
+// SaveNotificationDelivery inserts or updates a single delivery
+func (s *SQLStore) SaveNotificationDelivery(delivery *NotificationDelivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification delivery: %v", err)
+	}
+
+	_, err = s.exec(`INSERT INTO notification_deliveries (id, status, created_at, data) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET status = excluded.status, data = excluded.data`,
+		delivery.ID, delivery.Status, delivery.CreatedAt.UnixMilli(), string(data))
+	if err != nil {
+		return fmt.Errorf("failed to save notification delivery: %v", err)
+	}
+	return nil
+}
+
+// PruneNotificationDeliveries removes delivered and failed deliveries created before a time
+func (s *SQLStore) PruneNotificationDeliveries(before time.Time) error {
+	if _, err := s.exec("DELETE FROM notification_deliveries WHERE status <> ? AND created_at < ?",
+		deliveryPending, before.UnixMilli()); err != nil {
+		return fmt.Errorf("failed to prune notification deliveries: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/labstack/echo/v4"
+)
+
+// reloadDebounce groups the burst of events from a single save into one reload
+const reloadDebounce = 500 * time.Millisecond
+
+// ConfigReloader applies external changes to the config file and the stored services
+// without a restart
+type ConfigReloader struct {
+	configPath    string
+	settings      ConfigSettings // Settings in effect; changes need a restart
+	validator     echo.Validator
+	monitor       *MonitorService
+	notifications *NotificationService
+	storage       Store
+	mu            sync.Mutex // Serializes reloads
+}
+
+// NewConfigReloader creates a reloader. configPath and config are empty when no config file is used.
+func NewConfigReloader(configPath string, config *ConfigFile, validator echo.Validator, monitor *MonitorService, notifications *NotificationService, storage Store) *ConfigReloader {
+	reloader := &ConfigReloader{
+		configPath:    configPath,
+		validator:     validator,
+		monitor:       monitor,
+		notifications: notifications,
+		storage:       storage,
+	}
+	if config != nil {
+		reloader.settings = config.Settings
+	}
+	return reloader
+}
+
+// servicesFile returns the stored services file, or "" when services aren't stored in a file
+func (r *ConfigReloader) servicesFile() string {
+	if files, ok := r.storage.(*FileStore); ok {
+		return files.servicesFile
+	}
+	return ""
+}
+
+// Start reloads everything on SIGHUP, and the config file or services.json when they
+// change, until ctx is done
+func (r *ConfigReloader) Start(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// Watch the directories rather than the files, as atomic saves replace the files
+	configPath := absPath(r.configPath)
+	servicesPath := absPath(r.servicesFile())
+	watched := make(map[string]bool)
+	for _, path := range []string{configPath, servicesPath} {
+		if path != "" {
+			watched[path] = true
+		}
+	}
+
+	var events chan fsnotify.Event
+	var watchErrors chan error
+	if len(watched) > 0 {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			log.Printf("Warning: Failed to watch files, reload with SIGHUP instead: %v", err)
+		} else {
+			defer watcher.Close()
+			for path := range watched {
+				if err := watcher.Add(filepath.Dir(path)); err != nil {
+					log.Printf("Warning: Failed to watch %s, reload with SIGHUP instead: %v", path, err)
+				}
+			}
+			events = watcher.Events
+			watchErrors = watcher.Errors
+		}
+	}
+
+	changed := make(map[string]bool)
+	var debounce *time.Timer
+	var debounced <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-hup:
+			log.Println("Received SIGHUP, reloading configuration")
+			r.Reload()
+
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			path := absPath(event.Name)
+			if !watched[path] || !event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+				continue
+			}
+			changed[path] = true
+			if debounce == nil {
+				debounce = time.NewTimer(reloadDebounce)
+			} else {
+				debounce.Reset(reloadDebounce)
+			}
+			debounced = debounce.C
+
+		case err, ok := <-watchErrors:
+			if !ok {
+				watchErrors = nil
+				continue
+			}
+			log.Printf("Warning: File watcher error: %v", err)
+
+		case <-debounced:
+			debounced = nil
+			for path := range changed {
+				if path == servicesPath {
+					r.reloadStoredServices()
+				} else {
+					r.reloadConfigFile()
+				}
+			}
+			changed = make(map[string]bool)
+		}
+	}
+}
+
+// absPath returns the absolute, cleaned form of a path, or "" for an empty path
+func absPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// Reload applies the stored services, then the config file, which takes precedence
+func (r *ConfigReloader) Reload() {
+	r.reloadStoredServices()
+	r.reloadConfigFile()
+}
+
+// reloadStoredServices applies services changed in storage outside of Gjallarhorn
+func (r *ConfigReloader) reloadStoredServices() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Editors may briefly remove a file while saving; a missing file is not an empty service list
+	if path := r.servicesFile(); path != "" {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			log.Printf("Warning: %s is missing, keeping the current services", path)
+			return
+		}
+	}
+
+	created, updated, deleted, err := r.monitor.reloadStoredServices(r.validator)
+	if err != nil {
+		log.Printf("Warning: Failed to reload stored services: %v", err)
+		return
+	}
+	if created+updated+deleted > 0 {
+		log.Printf("Reloaded stored services: %d created, %d updated, %d deleted", created, updated, deleted)
+	}
+}
+
+// reloadConfigFile reconciles the running state with the config file. An invalid file
+// is reported and leaves the current configuration in place.
+func (r *ConfigReloader) reloadConfigFile() {
+	if r.configPath == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	config, err := LoadConfigFile(r.configPath, r.validator)
+	if err != nil {
+		log.Printf("Warning: Failed to reload config file, keeping the current configuration: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(config.Settings, r.settings) {
+		log.Printf("Warning: Changes to settings in %s take effect after a restart", r.configPath)
+	}
+	if err := config.Apply(r.monitor, r.notifications); err != nil {
+		log.Printf("Warning: Failed to apply config file: %v", err)
+	}
+}
+
+// reloadStoredServices replaces the running services with the stored ones. Services
+// whose configuration didn't change are kept as they are, and changed ones keep their
+// status and failure tracking, so reloading never resets an ongoing outage.
+func (m *MonitorService) reloadStoredServices(validator echo.Validator) (created, updated, deleted int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, err := m.storage.LoadServices()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	services := make(map[string]*Service, len(stored))
+	var changed []*Service
+	for id, service := range stored {
+		current, exists := m.services[id]
+
+		req := CreateServiceRequest{Name: service.Name, URL: service.URL, Interval: service.Interval, Tags: service.Tags}
+		if err := validator.Validate(&req); err != nil {
+			log.Printf("Warning: Ignoring invalid stored service %s: %v", id, err)
+			if exists {
+				services[id] = current
+			}
+			continue
+		}
+
+		if !exists {
+			services[id] = service
+			changed = append(changed, service)
+			created++
+			continue
+		}
+		if sameServiceConfig(current, service) {
+			services[id] = current
+			continue
+		}
+
+		merged := *current
+		merged.Name = service.Name
+		merged.URL = service.URL
+		merged.Interval = service.Interval
+		merged.Tags = service.Tags
+		merged.BadgeToken = service.BadgeToken
+		merged.Managed = service.Managed
+		merged.UpdatedAt = service.UpdatedAt
+		services[id] = &merged
+		changed = append(changed, &merged)
+		updated++
+	}
+
+	var deletedIDs []string
+	for id := range m.services {
+		if _, exists := services[id]; !exists {
+			deletedIDs = append(deletedIDs, id)
+		}
+	}
+
+	if len(changed) == 0 && len(deletedIDs) == 0 {
+		return 0, 0, 0, nil
+	}
+
+	previous := m.services
+	m.services = services
+	for _, service := range changed {
+		if _, existed := previous[service.ID]; existed {
+			relabelServiceMetrics(service)
+			m.publishServiceEvent("service.updated", service)
+		} else {
+			m.publishServiceEvent("service.created", service)
+		}
+	}
+	m.dropIncidentsLocked(deletedIDs...)
+	for _, id := range deletedIDs {
+		m.events.Publish(Event{Type: "service.deleted", ServiceID: id})
+		deleteServiceMetrics(id)
+	}
+
+	// Write the merged services back, so storage has the running status again
+	if err := m.saveServicesLocked(); err != nil {
+		return created, updated, len(deletedIDs), fmt.Errorf("failed to save reloaded services: %v", err)
+	}
+	return created, updated, len(deletedIDs), nil
+}
+
+// sameServiceConfig reports whether two services have the same user-editable configuration
+func sameServiceConfig(a, b *Service) bool {
+	return a.Name == b.Name && a.URL == b.URL && a.Interval == b.Interval && equalStrings(a.Tags, b.Tags) &&
+		a.BadgeToken == b.BadgeToken && a.Managed == b.Managed
+}
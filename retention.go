@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Rollup periods of the check_rollups table
+const (
+	rollupHour = "hour"
+	rollupDay  = "day"
+)
+
+// compactionInterval is how often check history is compacted
+const compactionInterval = 1 * time.Hour
+
+// RetentionPolicy controls how long check history is kept
+type RetentionPolicy struct {
+	Raw    time.Duration // Raw check results, rolled up when they expire
+	Hourly time.Duration // Hourly rollups
+	Daily  time.Duration // Daily rollups
+}
+
+// getRetentionDays returns a retention period in days from env or a default
+func getRetentionDays(name string, defaultDays int) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return time.Duration(defaultDays) * 24 * time.Hour
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 1 {
+		log.Printf("Invalid %s '%s', using default %d days", name, value, defaultDays)
+		return time.Duration(defaultDays) * 24 * time.Hour
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// getRetentionPolicy returns the check history retention policy from env
+func getRetentionPolicy() RetentionPolicy {
+	return RetentionPolicy{
+		Raw:    getRetentionDays("RETENTION_RAW_DAYS", 7),
+		Hourly: getRetentionDays("RETENTION_HOURLY_DAYS", 365),
+		Daily:  getRetentionDays("RETENTION_DAILY_DAYS", 365),
+	}
+}
+
+// StartCompaction periodically rolls up expired check results and removes expired rollups until ctx is done
+func (m *MonitorService) StartCompaction(ctx context.Context) {
+	policy := getRetentionPolicy()
+	log.Printf("Starting history compaction: raw checks kept %v, hourly rollups %v, daily rollups %v",
+		policy.Raw, policy.Hourly, policy.Daily)
+
+	ticker := time.NewTicker(compactionInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.storage.CompactHistory(policy, time.Now()); err != nil {
+			log.Printf("Warning: Failed to compact check history: %v", err)
+		}
+		m.pruneIncidents(time.Now().Add(-maxUptimeWindow))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pruneIncidents removes incidents resolved before a time. Incidents older than the longest
+// uptime window no longer affect any badge, and are scanned on every badge request otherwise.
+func (m *MonitorService) pruneIncidents(before time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := make([]*Incident, 0, len(m.incidents))
+	for _, incident := range m.incidents {
+		if incident.ResolvedAt == nil || !incident.ResolvedAt.Before(before) {
+			kept = append(kept, incident)
+		}
+	}
+	if len(kept) == len(m.incidents) {
+		return
+	}
+
+	if err := m.storage.SaveIncidents(kept); err != nil {
+		log.Printf("Warning: Failed to prune incidents: %v", err)
+		return
+	}
+	log.Printf("Pruned %d incidents resolved before %s", len(m.incidents)-len(kept), before.Format(time.RFC3339))
+	m.incidents = kept
+}
+
+// startOfDay returns midnight UTC of the day containing t
+func startOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// summarizeChecks aggregates check results into a rollup
+func summarizeChecks(results []*CheckResult) CheckRollup {
+	var rollup CheckRollup
+	var latencies []int64
+	var total int64
+
+	for _, result := range results {
+		rollup.Checks++
+		if result.Status != "online" {
+			rollup.Failures++
+			continue
+		}
+		rollup.Successes++
+		latencies = append(latencies, result.ResponseTime)
+		total += result.ResponseTime
+	}
+
+	if len(latencies) == 0 {
+		return rollup
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	rollup.MinResponseTime = latencies[0]
+	rollup.MaxResponseTime = latencies[len(latencies)-1]
+	rollup.AvgResponseTime = total / int64(len(latencies))
+	// Nearest-rank percentile
+	rank := int(math.Ceil(0.95*float64(len(latencies)))) - 1
+	rollup.P95ResponseTime = latencies[rank]
+	return rollup
+}
+
+// mergeRollups combines the rollups of two sets of check results in the same bucket. The
+// p95 of the combined checks can't be derived from the two rollups, so the larger one is
+// kept as an upper bound.
+func mergeRollups(a, b CheckRollup) CheckRollup {
+	merged := a
+	merged.Checks += b.Checks
+	merged.Successes += b.Successes
+	merged.Failures += b.Failures
+
+	// Latencies only cover successful checks
+	switch {
+	case b.Successes == 0:
+		return merged
+	case a.Successes == 0:
+		merged.MinResponseTime, merged.AvgResponseTime = b.MinResponseTime, b.AvgResponseTime
+		merged.MaxResponseTime, merged.P95ResponseTime = b.MaxResponseTime, b.P95ResponseTime
+		return merged
+	}
+
+	if b.MinResponseTime < merged.MinResponseTime {
+		merged.MinResponseTime = b.MinResponseTime
+	}
+	if b.MaxResponseTime > merged.MaxResponseTime {
+		merged.MaxResponseTime = b.MaxResponseTime
+	}
+	if b.P95ResponseTime > merged.P95ResponseTime {
+		merged.P95ResponseTime = b.P95ResponseTime
+	}
+	total := a.AvgResponseTime*int64(a.Successes) + b.AvgResponseTime*int64(b.Successes)
+	merged.AvgResponseTime = total / int64(merged.Successes)
+	return merged
+}
+
+// rollupChecks aggregates one day of a service's check results into hourly rollups and a daily rollup
+func rollupChecks(serviceID string, day time.Time, results []*CheckResult) []CheckRollup {
+	var rollups []CheckRollup
+	var hour []*CheckResult
+
+	// Results are ordered by time, so each hour is a contiguous run
+	for i, result := range results {
+		hour = append(hour, result)
+		bucket := result.Timestamp.Truncate(time.Hour)
+		if i+1 < len(results) && results[i+1].Timestamp.Truncate(time.Hour).Equal(bucket) {
+			continue
+		}
+		rollup := summarizeChecks(hour)
+		rollup.ServiceID = serviceID
+		rollup.Period = rollupHour
+		rollup.BucketStart = bucket
+		rollups = append(rollups, rollup)
+		hour = nil
+	}
+
+	daily := summarizeChecks(results)
+	daily.ServiceID = serviceID
+	daily.Period = rollupDay
+	daily.BucketStart = day
+	return append(rollups, daily)
+}
+
+// CompactHistory implements Store; the JSON store keeps no check history
+func (s *FileStore) CompactHistory(policy RetentionPolicy, now time.Time) error {
+	return nil
+}
+
+// CompactHistory rolls up whole days of check results older than the raw retention
+// period into hourly and daily rollups, then deletes rollups past their retention
+func (s *SQLStore) CompactHistory(policy RetentionPolicy, now time.Time) error {
+	rawCutoff := startOfDay(now.Add(-policy.Raw)).UnixMilli()
+	compacted := 0
+
+	for {
+		var oldest sql.NullInt64
+		err := s.db.QueryRow(s.rebind("SELECT MIN(checked_at) FROM check_results WHERE checked_at < ?"), rawCutoff).Scan(&oldest)
+		if err != nil {
+			return fmt.Errorf("failed to query check results: %v", err)
+		}
+		if !oldest.Valid {
+			break
+		}
+
+		day := startOfDay(time.UnixMilli(oldest.Int64))
+		count, err := s.compactDay(day)
+		if err != nil {
+			return err
+		}
+		compacted += count
+	}
+	if compacted > 0 {
+		log.Printf("Rolled up %d check results older than %v", compacted, policy.Raw)
+	}
+
+	removed := int64(0)
+	for period, retention := range map[string]time.Duration{rollupHour: policy.Hourly, rollupDay: policy.Daily} {
+		res, err := s.exec("DELETE FROM check_rollups WHERE period = ? AND bucket_start < ?",
+			period, now.Add(-retention).UnixMilli())
+		if err != nil {
+			return fmt.Errorf("failed to delete expired rollups: %v", err)
+		}
+		if n, err := res.RowsAffected(); err == nil {
+			removed += n
+		}
+	}
+	if removed > 0 {
+		log.Printf("Removed %d expired check rollups", removed)
+	}
+
+	return nil
+}
+
+// compactDay replaces the check results of one day with rollups, one service at a time
+func (s *SQLStore) compactDay(day time.Time) (int, error) {
+	start, end := day.UnixMilli(), day.AddDate(0, 0, 1).UnixMilli()
+
+	rows, err := s.db.Query(s.rebind("SELECT DISTINCT service_id FROM check_results WHERE checked_at >= ? AND checked_at < ?"), start, end)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query check results: %v", err)
+	}
+	var serviceIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan check result: %v", err)
+		}
+		serviceIDs = append(serviceIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to query check results: %v", err)
+	}
+
+	compacted := 0
+	for _, id := range serviceIDs {
+		count, err := s.compactServiceDay(id, day, start, end)
+		if err != nil {
+			return compacted, err
+		}
+		compacted += count
+	}
+	return compacted, nil
+}
+
+// compactServiceDay rolls up and deletes a service's check results for one day in a single transaction
+func (s *SQLStore) compactServiceDay(serviceID string, day time.Time, start, end int64) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(s.rebind(`SELECT checked_at, status, response_time FROM check_results
+		WHERE service_id = ? AND checked_at >= ? AND checked_at < ? ORDER BY checked_at`), serviceID, start, end)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query check results: %v", err)
+	}
+	var results []*CheckResult
+	for rows.Next() {
+		var checkedAt int64
+		result := &CheckResult{ServiceID: serviceID}
+		if err := rows.Scan(&checkedAt, &result.Status, &result.ResponseTime); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan check result: %v", err)
+		}
+		result.Timestamp = time.UnixMilli(checkedAt).UTC()
+		results = append(results, result)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to query check results: %v", err)
+	}
+
+	for _, rollup := range rollupChecks(serviceID, day, results) {
+		// Results written after the day was already rolled up are added to its rollups
+		existing := CheckRollup{ServiceID: rollup.ServiceID, Period: rollup.Period, BucketStart: rollup.BucketStart}
+		err := tx.QueryRow(s.rebind(`SELECT checks, successes, failures,
+			min_response_time, avg_response_time, max_response_time, p95_response_time
+			FROM check_rollups WHERE service_id = ? AND period = ? AND bucket_start = ?`),
+			rollup.ServiceID, rollup.Period, rollup.BucketStart.UnixMilli()).Scan(&existing.Checks, &existing.Successes, &existing.Failures,
+			&existing.MinResponseTime, &existing.AvgResponseTime, &existing.MaxResponseTime, &existing.P95ResponseTime)
+		switch {
+		case err == nil:
+			rollup = mergeRollups(existing, rollup)
+		case err != sql.ErrNoRows:
+			return 0, fmt.Errorf("failed to query check rollup: %v", err)
+		}
+
+		_, err = tx.Exec(s.rebind(`INSERT INTO check_rollups (service_id, period, bucket_start, checks, successes, failures,
+			min_response_time, avg_response_time, max_response_time, p95_response_time)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (service_id, period, bucket_start) DO UPDATE SET
+				checks = excluded.checks, successes = excluded.successes, failures = excluded.failures,
+				min_response_time = excluded.min_response_time, avg_response_time = excluded.avg_response_time,
+				max_response_time = excluded.max_response_time, p95_response_time = excluded.p95_response_time`),
+			rollup.ServiceID, rollup.Period, rollup.BucketStart.UnixMilli(), rollup.Checks, rollup.Successes, rollup.Failures,
+			rollup.MinResponseTime, rollup.AvgResponseTime, rollup.MaxResponseTime, rollup.P95ResponseTime)
+		if err != nil {
+			return 0, fmt.Errorf("failed to save check rollup: %v", err)
+		}
+	}
+
+	if _, err := tx.Exec(s.rebind("DELETE FROM check_results WHERE service_id = ? AND checked_at >= ? AND checked_at < ?"),
+		serviceID, start, end); err != nil {
+		return 0, fmt.Errorf("failed to delete check results: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit history compaction: %v", err)
+	}
+	return len(results), nil
+}
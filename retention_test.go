@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestMergeRollups(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b CheckRollup
+		want CheckRollup
+	}{
+		{
+			name: "both with successes",
+			a:    CheckRollup{Checks: 3, Successes: 2, Failures: 1, MinResponseTime: 100, AvgResponseTime: 150, MaxResponseTime: 200, P95ResponseTime: 200},
+			b:    CheckRollup{Checks: 2, Successes: 2, MinResponseTime: 50, AvgResponseTime: 300, MaxResponseTime: 550, P95ResponseTime: 550},
+			want: CheckRollup{Checks: 5, Successes: 4, Failures: 1, MinResponseTime: 50, AvgResponseTime: 225, MaxResponseTime: 550, P95ResponseTime: 550},
+		},
+		{
+			name: "only failures added",
+			a:    CheckRollup{Checks: 1, Successes: 1, MinResponseTime: 100, AvgResponseTime: 100, MaxResponseTime: 100, P95ResponseTime: 100},
+			b:    CheckRollup{Checks: 2, Failures: 2},
+			want: CheckRollup{Checks: 3, Successes: 1, Failures: 2, MinResponseTime: 100, AvgResponseTime: 100, MaxResponseTime: 100, P95ResponseTime: 100},
+		},
+		{
+			name: "successes added to only failures",
+			a:    CheckRollup{Checks: 2, Failures: 2},
+			b:    CheckRollup{Checks: 1, Successes: 1, MinResponseTime: 80, AvgResponseTime: 80, MaxResponseTime: 80, P95ResponseTime: 80},
+			want: CheckRollup{Checks: 3, Successes: 1, Failures: 2, MinResponseTime: 80, AvgResponseTime: 80, MaxResponseTime: 80, P95ResponseTime: 80},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeRollups(tt.a, tt.b); got != tt.want {
+				t.Errorf("mergeRollups = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
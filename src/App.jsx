@@ -4,10 +4,23 @@ import Header from './components/Header'
 import ServiceList from './components/ServiceList'
 import ServiceForm from './components/ServiceForm'
 import NotificationSettings from './components/NotificationSettings'
+import Login from './components/Login'
+import LoadingSpinner from './components/LoadingSpinner'
 import { ServiceProvider } from './context/ServiceContext'
 import { NotificationProvider } from './context/NotificationContext'
+import { AuthProvider, useAuth } from './context/AuthContext'
+
+function AuthenticatedApp() {
+  const { loading, authenticated } = useAuth()
+
+  if (loading) {
+    return <LoadingSpinner />
+  }
+
+  if (!authenticated) {
+    return <Login />
+  }
 
-function App() {
   return (
     <ServiceProvider>
       <NotificationProvider>
@@ -29,4 +42,12 @@ function App() {
   )
 }
 
+function App() {
+  return (
+    <AuthProvider>
+      <AuthenticatedApp />
+    </AuthProvider>
+  )
+}
+
 export default App
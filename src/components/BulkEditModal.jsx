@@ -42,6 +42,7 @@ const BulkEditModal = ({ isOpen, onClose }) => {
         name: s.name,
         url: s.url,
         interval: intervalNum,
+        tags: s.tags || [],
       }))
 
       await bulkUpdateServices(updates)
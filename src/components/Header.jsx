@@ -1,8 +1,12 @@
 import React from 'react'
 import { Link, useLocation } from 'react-router-dom'
+import { useAuth } from '../context/AuthContext'
+import { useNotifications } from '../context/NotificationContext'
 
 const Header = () => {
   const location = useLocation()
+  const { enabled, username, method, logout } = useAuth()
+  const { unhealthyChannels } = useNotifications()
 
   const isActive = (path) => {
     return location.pathname === path
@@ -40,6 +44,12 @@ const Header = () => {
                 }`}
               >
                 Settings
+                {unhealthyChannels.length > 0 && (
+                  <span
+                    className="inline-block w-2 h-2 ml-1.5 mb-0.5 bg-red-500 rounded-full"
+                    title="A notification channel is failing"
+                  />
+                )}
               </Link>
             </nav>
           </div>
@@ -51,6 +61,20 @@ const Header = () => {
             >
               Add Service
             </Link>
+            {enabled && (
+              <div className="flex items-center space-x-2">
+                {username && <span className="text-sm text-gray-600">{username}</span>}
+                {/* Proxy logins are managed by the proxy and can't be ended here */}
+                {method === 'session' && (
+                  <button
+                    onClick={logout}
+                    className="btn-secondary"
+                  >
+                    Log out
+                  </button>
+                )}
+              </div>
+            )}
           </div>
         </div>
       </div>
@@ -1,24 +1,49 @@
 import React, { useState, useEffect } from 'react'
 import { useNotifications } from '../context/NotificationContext'
+import { api } from '../services/api'
 import LoadingSpinner from './LoadingSpinner'
 
+const channelNames = {
+  pushover: 'Pushover',
+  webhook: 'Webhook',
+}
+
 const NotificationSettings = () => {
-  const { config, loading, updateConfig } = useNotifications()
+  const { config, channels, loading, updateConfig, fetchChannels } = useNotifications()
   const [formData, setFormData] = useState({
     userKey: '',
     appToken: '',
+    webhookUrl: '',
     enabled: false
   })
   const [saving, setSaving] = useState(false)
   const [error, setError] = useState('')
   const [success, setSuccess] = useState('')
+  const [warning, setWarning] = useState('')
+  const [attempts, setAttempts] = useState([])
+  const [testing, setTesting] = useState('')
+  const [testResults, setTestResults] = useState({})
 
   useEffect(() => {
     if (config) {
-      setFormData(config)
+      setFormData({ ...config, webhookUrl: config.webhookUrl || '' })
     }
   }, [config])
 
+  const fetchAttempts = async () => {
+    try {
+      const data = await api.getNotificationAttempts({ limit: 10 })
+      setAttempts(data)
+    } catch (err) {
+      console.error('Failed to fetch notification attempts:', err)
+    }
+  }
+
+  useEffect(() => {
+    fetchChannels()
+    fetchAttempts()
+  }, [])
+
   const handleChange = (e) => {
     const { name, value, type, checked } = e.target
     setFormData(prev => ({
@@ -32,10 +57,12 @@ const NotificationSettings = () => {
     setSaving(true)
     setError('')
     setSuccess('')
+    setWarning('')
 
     try {
-      await updateConfig(formData)
+      const response = await updateConfig(formData)
       setSuccess('Notification settings saved successfully!')
+      setWarning(response?.warning || '')
     } catch (err) {
       setError(err.message)
     } finally {
@@ -43,6 +70,20 @@ const NotificationSettings = () => {
     }
   }
 
+  const handleTest = async (channel) => {
+    setTesting(channel)
+    try {
+      const response = await api.testNotification(channel)
+      setTestResults(prev => ({ ...prev, [channel]: response.results[0] }))
+    } catch (err) {
+      setTestResults(prev => ({ ...prev, [channel]: { success: false, error: err.message } }))
+    } finally {
+      setTesting('')
+      fetchChannels()
+      fetchAttempts()
+    }
+  }
+
   if (loading) {
     return <LoadingSpinner />
   }
@@ -52,10 +93,74 @@ const NotificationSettings = () => {
       <div className="mb-8">
         <h1 className="text-2xl font-bold text-gray-900">Notification Settings</h1>
         <p className="text-gray-600 mt-2">
-          Configure Pushover notifications for service downtime alerts.
+          Configure Pushover and webhook notifications for service downtime alerts.
         </p>
       </div>
 
+      {channels.some(channel => channel.configured) && (
+        <div className="card mb-8">
+          <h2 className="text-lg font-semibold text-gray-900 mb-4">Channel Status</h2>
+          <div className="space-y-3">
+            {channels.filter(channel => channel.configured).map(channel => (
+              <div key={channel.channel} className="flex items-start justify-between">
+                <div>
+                  <p className="text-sm font-medium text-gray-900">{channelNames[channel.channel] || channel.channel}</p>
+                  {channel.lastError && (
+                    <p className="text-sm text-red-700 mt-1">
+                      {channel.consecutiveFailures} consecutive failures. Last error: {channel.lastError}
+                    </p>
+                  )}
+                  {channel.lastSuccessAt && (
+                    <p className="text-sm text-gray-500 mt-1">
+                      Last delivered {new Date(channel.lastSuccessAt).toLocaleString()}
+                    </p>
+                  )}
+                  {testResults[channel.channel] && (
+                    <p className={`text-sm mt-1 ${testResults[channel.channel].success ? 'text-green-700' : 'text-red-700'}`}>
+                      {testResults[channel.channel].success
+                        ? `Test sent (HTTP ${testResults[channel.channel].statusCode}, ${testResults[channel.channel].latency} ms)`
+                        : `Test failed: ${testResults[channel.channel].error}`}
+                    </p>
+                  )}
+                </div>
+                <div className="flex items-center space-x-3">
+                  <span className={channel.healthy ? 'status-online' : 'status-offline'}>
+                    {channel.healthy ? 'Healthy' : 'Unhealthy'}
+                  </span>
+                  <button
+                    type="button"
+                    onClick={() => handleTest(channel.channel)}
+                    disabled={testing !== ''}
+                    className="text-sm text-primary-600 hover:text-primary-700 disabled:opacity-50"
+                  >
+                    {testing === channel.channel ? 'Sending...' : 'Send Test'}
+                  </button>
+                </div>
+              </div>
+            ))}
+          </div>
+
+          {attempts.length > 0 && (
+            <div className="mt-6">
+              <h3 className="text-sm font-medium text-gray-700 mb-2">Recent Attempts</h3>
+              <ul className="divide-y divide-gray-200 text-sm">
+                {attempts.map(attempt => (
+                  <li key={attempt.id} className="py-2 flex justify-between">
+                    <span className="text-gray-700">
+                      {new Date(attempt.attemptedAt).toLocaleString()} · {channelNames[attempt.channel] || attempt.channel} · {attempt.event}
+                      {attempt.fallback && ' (fallback)'}
+                    </span>
+                    <span className={attempt.error ? 'text-red-700' : 'text-green-700'} title={attempt.error}>
+                      {attempt.error ? (attempt.statusCode ? `HTTP ${attempt.statusCode}` : 'Failed') : `${attempt.latency} ms`}
+                    </span>
+                  </li>
+                ))}
+              </ul>
+            </div>
+          )}
+        </div>
+      )}
+
       <div className="card">
         <form onSubmit={handleSubmit} className="space-y-6">
           {error && (
@@ -80,6 +185,12 @@ const NotificationSettings = () => {
             </div>
           )}
 
+          {warning && (
+            <div className="bg-yellow-50 border border-yellow-200 rounded-lg p-4">
+              <p className="text-yellow-800">{warning}</p>
+            </div>
+          )}
+
           <div className="flex items-center">
             <input
               type="checkbox"
@@ -90,7 +201,7 @@ const NotificationSettings = () => {
               className="h-4 w-4 text-primary-600 focus:ring-primary-500 border-gray-300 rounded"
             />
             <label htmlFor="enabled" className="ml-2 block text-sm font-medium text-gray-700">
-              Enable Pushover notifications
+              Enable notifications
             </label>
           </div>
 
@@ -138,6 +249,25 @@ const NotificationSettings = () => {
             </p>
           </div>
 
+          <div>
+            <label htmlFor="webhookUrl" className="block text-sm font-medium text-gray-700 mb-2">
+              Webhook URL
+            </label>
+            <input
+              type="url"
+              id="webhookUrl"
+              name="webhookUrl"
+              value={formData.webhookUrl}
+              onChange={handleChange}
+              className="input-field"
+              placeholder="https://example.com/hooks/gjallarhorn"
+            />
+            <p className="text-sm text-gray-500 mt-1">
+              Optional. Receives notifications as JSON. When Pushover is also configured, the webhook
+              is used only after Pushover fails 3 times in a row.
+            </p>
+          </div>
+
           <div className="bg-blue-50 border border-blue-200 rounded-lg p-4">
             <h3 className="text-sm font-medium text-blue-800 mb-2">How to get Pushover credentials:</h3>
             <ol className="text-sm text-blue-700 space-y-1 list-decimal list-inside">
@@ -10,7 +10,8 @@ const ServiceForm = () => {
   const [formData, setFormData] = useState({
     name: '',
     url: '',
-    interval: 60
+    interval: 60,
+    tags: ''
   })
   const [loading, setLoading] = useState(false)
   const [error, setError] = useState('')
@@ -24,7 +25,8 @@ const ServiceForm = () => {
         setFormData({
           name: service.name,
           url: service.url,
-          interval: service.interval
+          interval: service.interval,
+          tags: (service.tags || []).join(', ')
         })
       }
     }
@@ -43,11 +45,16 @@ const ServiceForm = () => {
     setLoading(true)
     setError('')
 
+    const payload = {
+      ...formData,
+      tags: formData.tags.split(',').map(tag => tag.trim()).filter(Boolean)
+    }
+
     try {
       if (isEdit) {
-        await updateService(id, formData)
+        await updateService(id, payload)
       } else {
-        await createService(formData)
+        await createService(payload)
       }
       navigate('/')
     } catch (err) {
@@ -139,6 +146,24 @@ const ServiceForm = () => {
             </p>
           </div>
 
+          <div>
+            <label htmlFor="tags" className="block text-sm font-medium text-gray-700 mb-2">
+              Tags
+            </label>
+            <input
+              type="text"
+              id="tags"
+              name="tags"
+              value={formData.tags}
+              onChange={handleChange}
+              className="input-field"
+              placeholder="e.g., prod, web"
+            />
+            <p className="text-sm text-gray-500 mt-1">
+              Comma-separated tags, exported as Prometheus labels
+            </p>
+          </div>
+
           <div className="flex space-x-4">
             <button
               type="submit"
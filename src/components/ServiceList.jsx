@@ -149,22 +149,38 @@ const ServiceList = () => {
                 </svg>
                 Last checked: {formatDate(service.lastChecked)}
               </div>
+
+              {service.tags?.length > 0 && (
+                <div className="flex flex-wrap gap-1">
+                  {service.tags.map(tag => (
+                    <span key={tag} className="px-2 py-0.5 rounded-full bg-gray-100 text-xs text-gray-700">
+                      {tag}
+                    </span>
+                  ))}
+                </div>
+              )}
             </div>
             
-            <div className="flex space-x-2">
-              <Link
-                to={`/edit/${service.id}`}
-                className="flex-1 btn-secondary text-center"
-              >
-                Edit
-              </Link>
-              <button
-                onClick={() => handleDelete(service.id, service.name)}
-                className="flex-1 btn-danger"
-              >
-                Delete
-              </button>
-            </div>
+            {service.managed ? (
+              <p className="text-sm text-gray-500 text-center">
+                Managed by the config file
+              </p>
+            ) : (
+              <div className="flex space-x-2">
+                <Link
+                  to={`/edit/${service.id}`}
+                  className="flex-1 btn-secondary text-center"
+                >
+                  Edit
+                </Link>
+                <button
+                  onClick={() => handleDelete(service.id, service.name)}
+                  className="flex-1 btn-danger"
+                >
+                  Delete
+                </button>
+              </div>
+            )}
           </div>
         ))}
       </div>
@@ -0,0 +1,64 @@
+import React, { createContext, useContext, useState, useEffect } from 'react'
+import { authApi, setUnauthorizedHandler } from '../services/api'
+
+const AuthContext = createContext()
+
+export const useAuth = () => {
+  const context = useContext(AuthContext)
+  if (!context) {
+    throw new Error('useAuth must be used within an AuthProvider')
+  }
+  return context
+}
+
+export const AuthProvider = ({ children }) => {
+  const [status, setStatus] = useState({ enabled: false, authenticated: false })
+  const [loading, setLoading] = useState(true)
+
+  const fetchStatus = async () => {
+    try {
+      setLoading(true)
+      const data = await authApi.getStatus()
+      setStatus(data)
+    } catch (err) {
+      console.error('Failed to fetch auth status:', err)
+    } finally {
+      setLoading(false)
+    }
+  }
+
+  const login = async (username, password) => {
+    const data = await authApi.login(username, password)
+    setStatus(data)
+  }
+
+  const logout = async () => {
+    try {
+      await authApi.logout()
+    } finally {
+      setStatus(prev => ({ ...prev, authenticated: false, username: undefined }))
+    }
+  }
+
+  useEffect(() => {
+    // Drop back to the login screen whenever the session expires
+    setUnauthorizedHandler(() => {
+      setStatus(prev => ({ ...prev, authenticated: false, username: undefined }))
+    })
+    fetchStatus()
+    return () => setUnauthorizedHandler(null)
+  }, [])
+
+  const value = {
+    ...status,
+    loading,
+    login,
+    logout,
+  }
+
+  return (
+    <AuthContext.Provider value={value}>
+      {children}
+    </AuthContext.Provider>
+  )
+}
@@ -15,8 +15,10 @@ export const NotificationProvider = ({ children }) => {
   const [config, setConfig] = useState({
     userKey: '',
     appToken: '',
+    webhookUrl: '',
     enabled: false
   })
+  const [channels, setChannels] = useState([])
   const [loading, setLoading] = useState(true)
 
   const fetchConfig = async () => {
@@ -31,19 +33,39 @@ export const NotificationProvider = ({ children }) => {
     }
   }
 
+  const fetchChannels = async () => {
+    try {
+      const data = await api.getNotificationChannels()
+      setChannels(data)
+    } catch (err) {
+      console.error('Failed to fetch notification channels:', err)
+    }
+  }
+
   const updateConfig = async (newConfig) => {
-    await api.updateNotificationConfig(newConfig)
+    const response = await api.updateNotificationConfig(newConfig)
     setConfig(newConfig)
+    fetchChannels()
+    return response
   }
 
   useEffect(() => {
     fetchConfig()
+    fetchChannels()
+    // Keep channel health current so failures show up without a reload
+    const interval = setInterval(fetchChannels, 60000)
+    return () => clearInterval(interval)
   }, [])
 
+  const unhealthyChannels = channels.filter(channel => channel.configured && !channel.healthy)
+
   const value = {
     config,
+    channels,
+    unhealthyChannels,
     loading,
     updateConfig,
+    fetchChannels,
   }
 
   return (
@@ -1,5 +1,5 @@
 import React, { createContext, useContext, useState, useEffect } from 'react'
-import { api } from '../services/api'
+import { api, eventsUrl } from '../services/api'
 
 const ServiceContext = createContext()
 
@@ -108,12 +108,50 @@ export const ServiceProvider = ({ children }) => {
     }
   }
 
+  // Apply a live update pushed by the server
+  const applyEvent = (event) => {
+    switch (event.type) {
+      case 'service.created':
+        setServices(prev => prev.some(service => service.id === event.serviceId)
+          ? prev
+          : [...prev, event.service])
+        break
+      case 'service.updated':
+      case 'service.checked':
+      case 'service.status':
+        setServices(prev => prev.map(service =>
+          service.id === event.serviceId ? event.service : service
+        ))
+        break
+      case 'service.deleted':
+        setServices(prev => prev.filter(service => service.id !== event.serviceId))
+        break
+      default:
+        break
+    }
+  }
+
   useEffect(() => {
     fetchServices()
-    
-    // Refresh services every 30 seconds
-    const interval = setInterval(fetchServices, 30000)
-    return () => clearInterval(interval)
+
+    // Subscribe to live updates instead of polling
+    const source = new EventSource(eventsUrl)
+    let connectedBefore = false
+    source.onopen = () => {
+      // Resync after a reconnect in case events were missed
+      if (connectedBefore) {
+        fetchServices()
+      }
+      connectedBefore = true
+    }
+    source.onmessage = (message) => {
+      try {
+        applyEvent(JSON.parse(message.data))
+      } catch (err) {
+        console.error('Failed to process live update', err)
+      }
+    }
+    return () => source.close()
   }, [])
 
   const value = {
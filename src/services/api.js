@@ -17,12 +17,22 @@ axiosInstance.interceptors.request.use(
   }
 )
 
+// Handler invoked when the API reports the session is no longer valid
+let unauthorizedHandler = null
+
+export const setUnauthorizedHandler = (handler) => {
+  unauthorizedHandler = handler
+}
+
 // Response interceptor
 axiosInstance.interceptors.response.use(
   (response) => {
     return response.data
   },
   (error) => {
+    if (error.response?.status === 401 && unauthorizedHandler) {
+      unauthorizedHandler()
+    }
     const message = error.response?.data?.error || error.message || 'An error occurred'
     return Promise.reject(new Error(message))
   }
@@ -44,10 +54,31 @@ export const bulkServiceApi = {
   bulkDelete: (ids) => axiosInstance.delete('/services/bulk', { data: { ids } }),
 }
 
+// Auth API
+export const authApi = {
+  getStatus: () => axiosInstance.get('/auth/me'),
+  login: (username, password) => axiosInstance.post('/auth/login', { username, password }),
+  logout: () => axiosInstance.post('/auth/logout'),
+  changePassword: (currentPassword, newPassword) =>
+    axiosInstance.put('/auth/password', { currentPassword, newPassword }),
+}
+
+// Live update stream (Server-Sent Events)
+export const eventsUrl = `${API_BASE_URL}/events`
+
 // Notification API
 export const notificationApi = {
   getConfig: () => axiosInstance.get('/notifications/config'),
   updateConfig: (data) => axiosInstance.post('/notifications/config', data),
+  getChannels: () => axiosInstance.get('/notifications/channels'),
+  getAttempts: (params) => axiosInstance.get('/notifications/attempts', { params }),
+  // Tests wait for the provider, so they may take longer than other requests. A 502 carries
+  // the provider's error in the results.
+  test: (channel) => axiosInstance.post('/notifications/test', null, {
+    params: { channel },
+    timeout: 30000,
+    validateStatus: (status) => status === 200 || status === 502,
+  }),
 }
 
 // Combined API object
@@ -56,6 +87,9 @@ export const api = {
   ...bulkServiceApi,
   getNotificationConfig: notificationApi.getConfig,
   updateNotificationConfig: notificationApi.updateConfig,
+  getNotificationChannels: notificationApi.getChannels,
+  getNotificationAttempts: notificationApi.getAttempts,
+  testNotification: notificationApi.test,
 }
 
 export default api
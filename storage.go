@@ -1,37 +1,283 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
+	"path/filepath"
 	"sync"
 )
 
-// StorageService handles persistent storage of services and configuration
-type StorageService struct {
-	servicesFile string
-	configFile   string
-	mu           sync.RWMutex
+// defaultDataDir is used when neither the -data-dir flag nor DATA_DIR is set
+const defaultDataDir = "/data"
+
+// dataDirFlag holds the -data-dir command line flag
+var dataDirFlag string
+
+// getDataDir returns the data directory from the -data-dir flag, DATA_DIR or the default
+func getDataDir() string {
+	if dataDirFlag != "" {
+		return dataDirFlag
+	}
+	if dir := os.Getenv("DATA_DIR"); dir != "" {
+		return dir
+	}
+	return defaultDataDir
+}
+
+// FileStore is a Store that keeps each kind of data in a JSON file in the data directory
+type FileStore struct {
+	dataDir        string
+	servicesFile   string
+	configFile     string
+	incidentsFile  string
+	authFile       string
+	keysFile       string
+	deliveriesFile string
+	attemptsFile   string
+	mu             sync.RWMutex
+
+	// Last saved services, incidents and notification deliveries and attempts,
+	// so a single record can be saved by rewriting its file
+	services   map[string]*Service
+	incidents  []*Incident
+	deliveries []*NotificationDelivery
+	attempts   []*NotificationAttempt
+
+	servicesHash [sha256.Size]byte // Of the services file as last read or written, to notice external edits
 }
 
-// NewStorageService creates a new storage service
-func NewStorageService() *StorageService {
-	return &StorageService{
-		servicesFile: "/data/services.json",
-		configFile:   "/data/config.json",
+// NewFileStore creates a file store in a data directory
+func NewFileStore(dataDir string) *FileStore {
+	return &FileStore{
+		dataDir:        dataDir,
+		servicesFile:   filepath.Join(dataDir, "services.json"),
+		configFile:     filepath.Join(dataDir, "config.json"),
+		incidentsFile:  filepath.Join(dataDir, "incidents.json"),
+		authFile:       filepath.Join(dataDir, "auth.json"),
+		keysFile:       filepath.Join(dataDir, "keys.json"),
+		deliveriesFile: filepath.Join(dataDir, "deliveries.json"),
+		attemptsFile:   filepath.Join(dataDir, "attempts.json"),
 	}
 }
 
-// ensureDataDir creates the data directory if it doesn't exist
-func (s *StorageService) ensureDataDir() error {
-	return os.MkdirAll("/data", 0755)
+// ensureDataDir creates the data directory if it doesn't exist.
+// It is only accessible by the owner since it holds credentials.
+func (s *FileStore) ensureDataDir() error {
+	return os.MkdirAll(s.dataDir, 0700)
+}
+
+// storageSnapshots is the number of previous versions kept of each data file
+const storageSnapshots = 3
+
+// snapshotPath returns the path of the nth previous version of a data file
+func snapshotPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// writeFileAtomic replaces a data file without ever leaving it half-written.
+// The data is written to a temporary file, synced and renamed over the original,
+// after the current version has been rotated into the snapshots.
+// Files are only readable by the owner since some hold credentials.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // No-op once renamed
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := rotateSnapshots(path); err != nil {
+		log.Printf("Warning: Failed to snapshot %s: %v", path, err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	// Persist the rename itself
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// rotateSnapshots shifts the snapshots of a data file and copies the current
+// version into the first one. Versions that aren't valid JSON are not kept so
+// a corrupt file never pushes out a good snapshot.
+func rotateSnapshots(path string) error {
+	current, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !json.Valid(current) {
+		return nil
+	}
+
+	for n := storageSnapshots - 1; n >= 1; n-- {
+		if err := os.Rename(snapshotPath(path, n), snapshotPath(path, n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	tmpPath := snapshotPath(path, 1) + ".tmp"
+	if err := os.WriteFile(tmpPath, current, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, snapshotPath(path, 1))
+}
+
+// readFileWithFallback reads a data file, falling back to the newest snapshot
+// that is valid JSON if the file is unreadable or corrupt
+func readFileWithFallback(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil && json.Valid(data) {
+		return data, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("invalid JSON")
+	}
+
+	for n := 1; n <= storageSnapshots; n++ {
+		snapshot, snapErr := os.ReadFile(snapshotPath(path, n))
+		if snapErr != nil || !json.Valid(snapshot) {
+			continue
+		}
+		log.Printf("Warning: %s is corrupt (%v), recovered the snapshot from %s. Changes made after that snapshot are lost.",
+			path, err, snapshotPath(path, n))
+		return snapshot, nil
+	}
+
+	return nil, fmt.Errorf("%s is corrupt and no valid snapshot was found: %v", path, err)
+}
+
+// SaveServices replaces all stored services and removes the incidents of services left out
+func (s *FileStore) SaveServices(services map[string]*Service) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.services = make(map[string]*Service, len(services))
+	for id, service := range services {
+		snapshot := *service
+		s.services[id] = &snapshot
+	}
+	if s.servicesFileHash() != s.servicesHash {
+		log.Printf("Warning: %s was changed outside Gjallarhorn and is being replaced before the change was reloaded", s.servicesFile)
+	}
+	if err := s.writeServicesLocked(); err != nil {
+		return err
+	}
+	return s.deleteRemovedIncidentsLocked()
+}
+
+// SaveService creates or updates a single stored service
+func (s *FileStore) SaveService(service *Service) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.loadServicesCacheLocked(); err != nil {
+		return err
+	}
+	snapshot := *service
+	s.services[service.ID] = &snapshot
+	return s.writeServicesLocked()
 }
 
-// SaveServices saves services to persistent storage
-func (s *StorageService) SaveServices(services map[string]*Service) error {
+// DeleteService removes a single stored service and its incidents
+func (s *FileStore) DeleteService(id string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if err := s.loadServicesCacheLocked(); err != nil {
+		return err
+	}
+	delete(s.services, id)
+	if err := s.writeServicesLocked(); err != nil {
+		return err
+	}
+	return s.deleteRemovedIncidentsLocked()
+}
+
+// deleteRemovedIncidentsLocked deletes the incidents of services that are no longer
+// stored, assuming the lock is held. The JSON store keeps no check history or
+// maintenance windows.
+func (s *FileStore) deleteRemovedIncidentsLocked() error {
+	if s.incidents == nil {
+		incidents, err := s.readIncidentsFile()
+		if err != nil {
+			return err
+		}
+		s.incidents = incidents
+	}
+
+	kept := make([]*Incident, 0, len(s.incidents))
+	for _, incident := range s.incidents {
+		if _, exists := s.services[incident.ServiceID]; exists {
+			kept = append(kept, incident)
+		}
+	}
+	if len(kept) == len(s.incidents) {
+		return nil
+	}
+	s.incidents = kept
+	return s.writeIncidentsLocked()
+}
+
+// loadServicesCacheLocked reads the services file into the cache if nothing has been loaded
+// yet, or if the file was changed outside Gjallarhorn since it was last read or written, so
+// saving one service doesn't overwrite an edit the reload hasn't picked up yet
+func (s *FileStore) loadServicesCacheLocked() error {
+	hash := s.servicesFileHash()
+	if s.services != nil && hash == s.servicesHash {
+		return nil
+	}
+	if s.services != nil {
+		log.Printf("%s was changed outside Gjallarhorn, reading it again before saving", s.servicesFile)
+	}
+
+	services, err := s.readServicesFile()
+	if err != nil {
+		return err
+	}
+	s.services = services
+	s.servicesHash = hash
+	return nil
+}
+
+// servicesFileHash returns the SHA-256 of the services file, or zero when it can't be read
+func (s *FileStore) servicesFileHash() [sha256.Size]byte {
+	data, err := os.ReadFile(s.servicesFile)
+	if err != nil {
+		return [sha256.Size]byte{}
+	}
+	return sha256.Sum256(data)
+}
+
+// writeServicesLocked writes the cached services to the services file, assuming the lock is held
+func (s *FileStore) writeServicesLocked() error {
+	services := s.services
 	if err := s.ensureDataDir(); err != nil {
 		return fmt.Errorf("failed to create data directory: %v", err)
 	}
@@ -41,18 +287,36 @@ func (s *StorageService) SaveServices(services map[string]*Service) error {
 		return fmt.Errorf("failed to marshal services: %v", err)
 	}
 
-	if err := os.WriteFile(s.servicesFile, data, 0644); err != nil {
+	if err := writeFileAtomic(s.servicesFile, data); err != nil {
 		return fmt.Errorf("failed to write services file: %v", err)
 	}
+	s.servicesHash = sha256.Sum256(data)
 
 	return nil
 }
 
 // LoadServices loads services from persistent storage
-func (s *StorageService) LoadServices() (map[string]*Service, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *FileStore) LoadServices() (map[string]*Service, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := s.servicesFileHash()
+	services, err := s.readServicesFile()
+	if err != nil {
+		return nil, err
+	}
+	s.servicesHash = hash
+
+	s.services = make(map[string]*Service, len(services))
+	for id, service := range services {
+		snapshot := *service
+		s.services[id] = &snapshot
+	}
+	return services, nil
+}
 
+// readServicesFile reads the services file
+func (s *FileStore) readServicesFile() (map[string]*Service, error) {
 	if err := s.ensureDataDir(); err != nil {
 		return nil, fmt.Errorf("failed to create data directory: %v", err)
 	}
@@ -63,7 +327,7 @@ func (s *StorageService) LoadServices() (map[string]*Service, error) {
 		return make(map[string]*Service), nil
 	}
 
-	data, err := os.ReadFile(s.servicesFile)
+	data, err := readFileWithFallback(s.servicesFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read services file: %v", err)
 	}
@@ -82,7 +346,7 @@ func (s *StorageService) LoadServices() (map[string]*Service, error) {
 }
 
 // SaveNotificationConfig saves notification configuration to persistent storage
-func (s *StorageService) SaveNotificationConfig(config *NotificationConfig) error {
+func (s *FileStore) SaveNotificationConfig(config *NotificationConfig) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -95,7 +359,7 @@ func (s *StorageService) SaveNotificationConfig(config *NotificationConfig) erro
 		return fmt.Errorf("failed to marshal config: %v", err)
 	}
 
-	if err := os.WriteFile(s.configFile, data, 0644); err != nil {
+	if err := writeFileAtomic(s.configFile, data); err != nil {
 		return fmt.Errorf("failed to write config file: %v", err)
 	}
 
@@ -103,7 +367,7 @@ func (s *StorageService) SaveNotificationConfig(config *NotificationConfig) erro
 }
 
 // LoadNotificationConfig loads notification configuration from persistent storage
-func (s *StorageService) LoadNotificationConfig() (*NotificationConfig, error) {
+func (s *FileStore) LoadNotificationConfig() (*NotificationConfig, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -121,7 +385,7 @@ func (s *StorageService) LoadNotificationConfig() (*NotificationConfig, error) {
 		}, nil
 	}
 
-	data, err := os.ReadFile(s.configFile)
+	data, err := readFileWithFallback(s.configFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %v", err)
 	}
@@ -133,3 +397,217 @@ func (s *StorageService) LoadNotificationConfig() (*NotificationConfig, error) {
 
 	return &config, nil
 }
+
+// SaveIncidents replaces the stored incident history
+func (s *FileStore) SaveIncidents(incidents []*Incident) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.incidents = make([]*Incident, 0, len(incidents))
+	for _, incident := range incidents {
+		snapshot := *incident
+		s.incidents = append(s.incidents, &snapshot)
+	}
+	return s.writeIncidentsLocked()
+}
+
+// SaveIncident creates or updates a single stored incident
+func (s *FileStore) SaveIncident(incident *Incident) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.incidents == nil {
+		incidents, err := s.readIncidentsFile()
+		if err != nil {
+			return err
+		}
+		s.incidents = incidents
+	}
+
+	snapshot := *incident
+	for i, existing := range s.incidents {
+		if existing.ID == incident.ID {
+			s.incidents[i] = &snapshot
+			return s.writeIncidentsLocked()
+		}
+	}
+	s.incidents = append(s.incidents, &snapshot)
+	return s.writeIncidentsLocked()
+}
+
+// writeIncidentsLocked writes the cached incidents to the incidents file, assuming the lock is held
+func (s *FileStore) writeIncidentsLocked() error {
+	incidents := s.incidents
+	if err := s.ensureDataDir(); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(incidents, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal incidents: %v", err)
+	}
+
+	if err := writeFileAtomic(s.incidentsFile, data); err != nil {
+		return fmt.Errorf("failed to write incidents file: %v", err)
+	}
+
+	return nil
+}
+
+// LoadIncidents loads the incident history from persistent storage
+func (s *FileStore) LoadIncidents() ([]*Incident, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	incidents, err := s.readIncidentsFile()
+	if err != nil {
+		return nil, err
+	}
+
+	s.incidents = make([]*Incident, 0, len(incidents))
+	for _, incident := range incidents {
+		snapshot := *incident
+		s.incidents = append(s.incidents, &snapshot)
+	}
+	return incidents, nil
+}
+
+// readIncidentsFile reads the incidents file
+func (s *FileStore) readIncidentsFile() ([]*Incident, error) {
+	if err := s.ensureDataDir(); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	// Check if file exists
+	if _, err := os.Stat(s.incidentsFile); os.IsNotExist(err) {
+		// File doesn't exist, no incidents recorded yet
+		return []*Incident{}, nil
+	}
+
+	data, err := readFileWithFallback(s.incidentsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read incidents file: %v", err)
+	}
+
+	var incidents []*Incident
+	if err := json.Unmarshal(data, &incidents); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal incidents: %v", err)
+	}
+
+	if incidents == nil {
+		incidents = []*Incident{}
+	}
+
+	return incidents, nil
+}
+
+// SaveAuthConfig saves the admin credentials to persistent storage
+func (s *FileStore) SaveAuthConfig(config *AuthConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.ensureDataDir(); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal auth config: %v", err)
+	}
+
+	if err := writeFileAtomic(s.authFile, data); err != nil {
+		return fmt.Errorf("failed to write auth file: %v", err)
+	}
+
+	return nil
+}
+
+// LoadAuthConfig loads the admin credentials from persistent storage.
+// It returns nil when no admin user has been configured yet.
+func (s *FileStore) LoadAuthConfig() (*AuthConfig, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.ensureDataDir(); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	// Check if file exists
+	if _, err := os.Stat(s.authFile); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	data, err := readFileWithFallback(s.authFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth file: %v", err)
+	}
+
+	var config AuthConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal auth config: %v", err)
+	}
+
+	return &config, nil
+}
+
+// SaveAPIKeys saves the hashed API keys to persistent storage
+func (s *FileStore) SaveAPIKeys(keys []*APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.ensureDataDir(); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal API keys: %v", err)
+	}
+
+	if err := writeFileAtomic(s.keysFile, data); err != nil {
+		return fmt.Errorf("failed to write API keys file: %v", err)
+	}
+
+	return nil
+}
+
+// LoadAPIKeys loads the hashed API keys from persistent storage
+func (s *FileStore) LoadAPIKeys() ([]*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.ensureDataDir(); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	// Check if file exists
+	if _, err := os.Stat(s.keysFile); os.IsNotExist(err) {
+		return []*APIKey{}, nil
+	}
+
+	data, err := readFileWithFallback(s.keysFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys file: %v", err)
+	}
+
+	var keys []*APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal API keys: %v", err)
+	}
+
+	if keys == nil {
+		keys = []*APIKey{}
+	}
+
+	return keys, nil
+}
+
+// SaveCheckResult discards the result; check history is only kept by SQL stores
+func (s *FileStore) SaveCheckResult(result *CheckResult) error {
+	return nil
+}
+
+// Close implements Store; files are closed after every write
+func (s *FileStore) Close() error {
+	return nil
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"  // PostgreSQL driver, registered as "postgres"
+	_ "modernc.org/sqlite" // Pure-Go SQLite driver, registered as "sqlite"
+)
+
+// SQL dialects supported by SQLStore
+const (
+	dialectSQLite   = "sqlite"
+	dialectPostgres = "postgres"
+)
+
+// sqlSchema creates the tables of schema version 1. Times are stored as Unix milliseconds.
+// {{autoincrement}} is replaced with the dialect's auto-incrementing key.
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS services (
+		id   TEXT PRIMARY KEY,
+		data TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS notification_channels (
+		name   TEXT PRIMARY KEY,
+		config TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS check_results (
+		id            {{autoincrement}},
+		service_id    TEXT NOT NULL,
+		checked_at    BIGINT NOT NULL,
+		status        TEXT NOT NULL,
+		response_time BIGINT NOT NULL,
+		error         TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX IF NOT EXISTS check_results_service_time ON check_results (service_id, checked_at)`,
+	`CREATE TABLE IF NOT EXISTS incidents (
+		id          TEXT PRIMARY KEY,
+		service_id  TEXT NOT NULL,
+		started_at  BIGINT NOT NULL,
+		resolved_at BIGINT,
+		error       TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX IF NOT EXISTS incidents_service_time ON incidents (service_id, started_at)`,
+	`CREATE TABLE IF NOT EXISTS maintenance_windows (
+		id         TEXT PRIMARY KEY,
+		service_id TEXT,
+		starts_at  BIGINT NOT NULL,
+		ends_at    BIGINT NOT NULL,
+		reason     TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS settings (
+		key   TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	)`,
+}
+
+// Keys of the settings table
+const (
+	settingAuthConfig   = "auth"
+	settingAPIKeys      = "api_keys"
+	settingJSONImported = "json_imported"
+)
+
+// SQLStore is a Store backed by SQLite or PostgreSQL
+type SQLStore struct {
+	db      *sql.DB
+	dialect string
+}
+
+// getSQLitePath returns the database path from SQLITE_PATH, or gjallarhorn.db in the data directory
+func getSQLitePath(dataDir string) string {
+	if path := os.Getenv("SQLITE_PATH"); path != "" {
+		return path
+	}
+	return filepath.Join(dataDir, "gjallarhorn.db")
+}
+
+// NewSQLStore opens a SQLite database file or PostgreSQL connection string and
+// migrates it to the latest schema. SQLite backups taken before migrating go to backupDir.
+func NewSQLStore(dialect, dsn, backupDir string) (*SQLStore, error) {
+	db, err := sql.Open(dialect, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if dialect == dialectSQLite {
+		// SQLite allows a single writer; one connection also keeps the pragmas below in effect
+		db.SetMaxOpenConns(1)
+		for _, pragma := range []string{
+			"PRAGMA journal_mode = WAL",
+			"PRAGMA synchronous = NORMAL",
+			"PRAGMA busy_timeout = 5000",
+		} {
+			if _, err := db.Exec(pragma); err != nil {
+				db.Close()
+				return nil, fmt.Errorf("failed to initialize %s database: %v", dialect, err)
+			}
+		}
+	}
+
+	store := &SQLStore{db: db, dialect: dialect}
+	if err := store.migrate(backupDir); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// rebind rewrites ? placeholders to $1, $2, ... for PostgreSQL
+func (s *SQLStore) rebind(query string) string {
+	if s.dialect != dialectPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// exec runs a statement outside a transaction
+func (s *SQLStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.db.Exec(s.rebind(query), args...)
+}
+
+// Close closes the database
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// nullUnixMillis converts an optional time to nullable Unix milliseconds
+func nullUnixMillis(t *time.Time) sql.NullInt64 {
+	if t == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.UnixMilli(), Valid: true}
+}
+
+// jsonImported reports whether JSON data files have already been imported
+func (s *SQLStore) jsonImported() (bool, error) {
+	var value string
+	err := s.db.QueryRow(s.rebind("SELECT value FROM settings WHERE key = ?"), settingJSONImported).Scan(&value)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to query settings: %v", err)
+	}
+	return true, nil
+}
+
+// markJSONImported records that JSON data files have been imported
+func (s *SQLStore) markJSONImported() error {
+	return s.saveSetting(settingJSONImported, time.Now().Format(time.RFC3339))
+}
+
+// SaveServices replaces all services in a single transaction
+func (s *SQLStore) SaveServices(services map[string]*Service) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM services"); err != nil {
+		return fmt.Errorf("failed to clear services: %v", err)
+	}
+	for _, service := range services {
+		data, err := json.Marshal(service)
+		if err != nil {
+			return fmt.Errorf("failed to marshal service: %v", err)
+		}
+		if _, err := tx.Exec(s.rebind("INSERT INTO services (id, data) VALUES (?, ?)"), service.ID, string(data)); err != nil {
+			return fmt.Errorf("failed to insert service: %v", err)
+		}
+	}
+	if err := deleteRemovedServiceData(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit services: %v", err)
+	}
+	return nil
+}
+
+// deleteRemovedServiceData deletes the check history, incidents and maintenance windows
+// of services that are no longer stored, as DeleteService does for a single service
+func deleteRemovedServiceData(tx *sql.Tx) error {
+	for _, table := range []string{"check_results", "check_rollups", "incidents", "maintenance_windows"} {
+		// Windows without a service have a NULL service_id, which NOT IN never matches
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE service_id NOT IN (SELECT id FROM services)", table)); err != nil {
+			return fmt.Errorf("failed to delete %s of removed services: %v", table, err)
+		}
+	}
+	return nil
+}
+
+// SaveService inserts or updates a single service
+func (s *SQLStore) SaveService(service *Service) error {
+	data, err := json.Marshal(service)
+	if err != nil {
+		return fmt.Errorf("failed to marshal service: %v", err)
+	}
+
+	_, err = s.exec(`INSERT INTO services (id, data) VALUES (?, ?)
+		ON CONFLICT (id) DO UPDATE SET data = excluded.data`, service.ID, string(data))
+	if err != nil {
+		return fmt.Errorf("failed to save service: %v", err)
+	}
+	return nil
+}
+
+// DeleteService deletes a service along with its check history, incidents and maintenance windows
+func (s *SQLStore) DeleteService(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		"DELETE FROM services WHERE id = ?",
+		"DELETE FROM check_results WHERE service_id = ?",
+		"DELETE FROM check_rollups WHERE service_id = ?",
+		"DELETE FROM incidents WHERE service_id = ?",
+		"DELETE FROM maintenance_windows WHERE service_id = ?",
+	} {
+		if _, err := tx.Exec(s.rebind(stmt), id); err != nil {
+			return fmt.Errorf("failed to delete service: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit service deletion: %v", err)
+	}
+	return nil
+}
+
+// LoadServices loads all services
+func (s *SQLStore) LoadServices() (map[string]*Service, error) {
+	rows, err := s.db.Query("SELECT data FROM services")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query services: %v", err)
+	}
+	defer rows.Close()
+
+	services := make(map[string]*Service)
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to read service: %v", err)
+		}
+		var service Service
+		if err := json.Unmarshal([]byte(data), &service); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal service: %v", err)
+		}
+		services[service.ID] = &service
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read services: %v", err)
+	}
+
+	return services, nil
+}
+
+// SaveNotificationConfig saves the Pushover channel configuration
+func (s *SQLStore) SaveNotificationConfig(config *NotificationConfig) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+
+	_, err = s.exec(`INSERT INTO notification_channels (name, config) VALUES (?, ?)
+		ON CONFLICT (name) DO UPDATE SET config = excluded.config`, "pushover", string(data))
+	if err != nil {
+		return fmt.Errorf("failed to save notification config: %v", err)
+	}
+	return nil
+}
+
+// LoadNotificationConfig loads the Pushover channel configuration
+func (s *SQLStore) LoadNotificationConfig() (*NotificationConfig, error) {
+	var data string
+	err := s.db.QueryRow(s.rebind("SELECT config FROM notification_channels WHERE name = ?"), "pushover").Scan(&data)
+	if err == sql.ErrNoRows {
+		return &NotificationConfig{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notification config: %v", err)
+	}
+
+	var config NotificationConfig
+	if err := json.Unmarshal([]byte(data), &config); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %v", err)
+	}
+	return &config, nil
+}
+
+// SaveIncidents replaces all incidents in a single transaction
+func (s *SQLStore) SaveIncidents(incidents []*Incident) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM incidents"); err != nil {
+		return fmt.Errorf("failed to clear incidents: %v", err)
+	}
+	for _, incident := range incidents {
+		if _, err := tx.Exec(s.rebind("INSERT INTO incidents (id, service_id, started_at, resolved_at, error) VALUES (?, ?, ?, ?, ?)"),
+			incident.ID, incident.ServiceID, incident.StartedAt.UnixMilli(), nullUnixMillis(incident.ResolvedAt), incident.Error); err != nil {
+			return fmt.Errorf("failed to insert incident: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit incidents: %v", err)
+	}
+	return nil
+}
+
+// SaveIncident inserts or updates a single incident
+func (s *SQLStore) SaveIncident(incident *Incident) error {
+	_, err := s.exec(`INSERT INTO incidents (id, service_id, started_at, resolved_at, error) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET resolved_at = excluded.resolved_at, error = excluded.error`,
+		incident.ID, incident.ServiceID, incident.StartedAt.UnixMilli(), nullUnixMillis(incident.ResolvedAt), incident.Error)
+	if err != nil {
+		return fmt.Errorf("failed to save incident: %v", err)
+	}
+	return nil
+}
+
+// LoadIncidents loads all incidents, oldest first
+func (s *SQLStore) LoadIncidents() ([]*Incident, error) {
+	rows, err := s.db.Query("SELECT id, service_id, started_at, resolved_at, error FROM incidents ORDER BY started_at")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query incidents: %v", err)
+	}
+	defer rows.Close()
+
+	incidents := []*Incident{}
+	for rows.Next() {
+		var incident Incident
+		var startedAt int64
+		var resolvedAt sql.NullInt64
+		if err := rows.Scan(&incident.ID, &incident.ServiceID, &startedAt, &resolvedAt, &incident.Error); err != nil {
+			return nil, fmt.Errorf("failed to read incident: %v", err)
+		}
+		incident.StartedAt = time.UnixMilli(startedAt)
+		if resolvedAt.Valid {
+			resolved := time.UnixMilli(resolvedAt.Int64)
+			incident.ResolvedAt = &resolved
+		}
+		incidents = append(incidents, &incident)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read incidents: %v", err)
+	}
+
+	return incidents, nil
+}
+
+// SaveCheckResult appends a health check result to the history
+func (s *SQLStore) SaveCheckResult(result *CheckResult) error {
+	_, err := s.exec("INSERT INTO check_results (service_id, checked_at, status, response_time, error) VALUES (?, ?, ?, ?, ?)",
+		result.ServiceID, result.Timestamp.UnixMilli(), result.Status, result.ResponseTime, result.Error)
+	if err != nil {
+		return fmt.Errorf("failed to save check result: %v", err)
+	}
+	return nil
+}
+
+// saveSetting stores a JSON-encoded value in the settings table
+func (s *SQLStore) saveSetting(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %v", key, err)
+	}
+
+	_, err = s.exec(`INSERT INTO settings (key, value) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value`, key, string(data))
+	if err != nil {
+		return fmt.Errorf("failed to save %s: %v", key, err)
+	}
+	return nil
+}
+
+// loadSetting decodes a JSON-encoded value from the settings table.
+// It reports false when the setting doesn't exist.
+func (s *SQLStore) loadSetting(key string, value interface{}) (bool, error) {
+	var data string
+	err := s.db.QueryRow(s.rebind("SELECT value FROM settings WHERE key = ?"), key).Scan(&data)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to query %s: %v", key, err)
+	}
+
+	if err := json.Unmarshal([]byte(data), value); err != nil {
+		return false, fmt.Errorf("failed to unmarshal %s: %v", key, err)
+	}
+	return true, nil
+}
+
+// SaveAuthConfig saves the admin credentials
+func (s *SQLStore) SaveAuthConfig(config *AuthConfig) error {
+	return s.saveSetting(settingAuthConfig, config)
+}
+
+// LoadAuthConfig loads the admin credentials, returning nil when none are configured
+func (s *SQLStore) LoadAuthConfig() (*AuthConfig, error) {
+	var config AuthConfig
+	found, err := s.loadSetting(settingAuthConfig, &config)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// SaveAPIKeys saves the hashed API keys
+func (s *SQLStore) SaveAPIKeys(keys []*APIKey) error {
+	return s.saveSetting(settingAPIKeys, keys)
+}
+
+// LoadAPIKeys loads the hashed API keys
+func (s *SQLStore) LoadAPIKeys() ([]*APIKey, error) {
+	keys := []*APIKey{}
+	if _, err := s.loadSetting(settingAPIKeys, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -0,0 +1,666 @@
+package main
+
+import (
+	"database/sql"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// sqlTestDatabase creates empty databases of one SQL dialect for tests
+type sqlTestDatabase struct {
+	dialect string
+	newDSN  func(t *testing.T) string // Returns the DSN of a new, empty database
+}
+
+// sqlTestDatabases returns the databases to run the SQL store tests against: SQLite
+// always, and PostgreSQL when TEST_DATABASE_URL is set. Each PostgreSQL test gets its
+// own schema, which is dropped afterwards.
+func sqlTestDatabases() []sqlTestDatabase {
+	databases := []sqlTestDatabase{{
+		dialect: dialectSQLite,
+		newDSN: func(t *testing.T) string {
+			return filepath.Join(t.TempDir(), "gjallarhorn.db")
+		},
+	}}
+
+	if dsn := os.Getenv("TEST_DATABASE_URL"); dsn != "" {
+		databases = append(databases, sqlTestDatabase{
+			dialect: dialectPostgres,
+			newDSN: func(t *testing.T) string {
+				return newPostgresTestSchema(t, dsn)
+			},
+		})
+	}
+	return databases
+}
+
+// newPostgresTestSchema creates a schema for one test and returns a DSN that uses it
+func newPostgresTestSchema(t *testing.T, dsn string) string {
+	t.Helper()
+	db, err := sql.Open(dialectPostgres, dsn)
+	if err != nil {
+		t.Fatalf("failed to connect to TEST_DATABASE_URL: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	schema := "gjallarhorn_test_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := db.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("failed to drop schema %s: %v", schema, err)
+		}
+	})
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatalf("invalid TEST_DATABASE_URL: %v", err)
+		}
+		query := u.Query()
+		query.Set("search_path", schema)
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+	return dsn + " search_path=" + schema
+}
+
+// forEachSQLDatabase runs a test against a new database of each dialect
+func forEachSQLDatabase(t *testing.T, test func(t *testing.T, dialect, dsn string)) {
+	for _, database := range sqlTestDatabases() {
+		t.Run(database.dialect, func(t *testing.T) {
+			test(t, database.dialect, database.newDSN(t))
+		})
+	}
+}
+
+// openTestSQLStore opens a migrated store in a new database
+func openTestSQLStore(t *testing.T, dialect, dsn string) *SQLStore {
+	t.Helper()
+	store, err := NewSQLStore(dialect, dsn, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewSQLStore failed: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// testTime returns a time that survives the millisecond precision of the SQL store
+func testTime(offset time.Duration) time.Time {
+	return time.UnixMilli(time.Now().Add(offset).UnixMilli())
+}
+
+func TestSQLStore(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s *SQLStore)
+	}{
+		{"services", testSQLStoreServices},
+		{"delete service removes its history", testSQLStoreDeleteService},
+		{"save services removes the history of services left out", testSQLStoreSaveServicesRemovesHistory},
+		{"incidents", testSQLStoreIncidents},
+		{"notification config", testSQLStoreNotificationConfig},
+		{"notification deliveries", testSQLStoreNotificationDeliveries},
+		{"notification attempts", testSQLStoreNotificationAttempts},
+		{"auth config and API keys", testSQLStoreAuth},
+		{"check history compaction", testSQLStoreCompactHistory},
+		{"restore", testSQLStoreRestore},
+		{"ping", testSQLStorePing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachSQLDatabase(t, func(t *testing.T, dialect, dsn string) {
+				tt.run(t, openTestSQLStore(t, dialect, dsn))
+			})
+		})
+	}
+}
+
+func testSQLStoreServices(t *testing.T, s *SQLStore) {
+	services, err := s.LoadServices()
+	if err != nil || len(services) != 0 {
+		t.Fatalf("LoadServices on a new database = %v, %v; want no services", services, err)
+	}
+
+	offlineAt := testTime(-time.Hour)
+	web := &Service{ID: "web", Name: "Web", URL: "https://example.com", Interval: 60, Status: "offline",
+		Tags: []string{"prod"}, WentOfflineAt: &offlineAt, CreatedAt: testTime(-24 * time.Hour)}
+	api := &Service{ID: "api", Name: "API", URL: "https://api.example.com", Interval: 30, Status: "online"}
+	if err := s.SaveServices(map[string]*Service{"web": web, "api": api}); err != nil {
+		t.Fatalf("SaveServices failed: %v", err)
+	}
+
+	updated := *api
+	updated.Status = "offline"
+	updated.ConsecutiveFailures = 3
+	if err := s.SaveService(&updated); err != nil {
+		t.Fatalf("SaveService failed: %v", err)
+	}
+	db := &Service{ID: "db", Name: "DB", URL: "https://db.example.com", Interval: 300}
+	if err := s.SaveService(db); err != nil {
+		t.Fatalf("SaveService failed: %v", err)
+	}
+
+	services, err = s.LoadServices()
+	if err != nil {
+		t.Fatalf("LoadServices failed: %v", err)
+	}
+	want := map[string]*Service{"web": web, "api": &updated, "db": db}
+	if len(services) != len(want) {
+		t.Fatalf("LoadServices returned %d services, want %d", len(services), len(want))
+	}
+	for id, service := range want {
+		got := services[id]
+		if got == nil || got.Name != service.Name || got.Status != service.Status ||
+			got.ConsecutiveFailures != service.ConsecutiveFailures || !reflect.DeepEqual(got.Tags, service.Tags) {
+			t.Errorf("service %s = %+v, want %+v", id, got, service)
+		}
+	}
+	if got := services["web"].WentOfflineAt; got == nil || !got.Equal(offlineAt) {
+		t.Errorf("WentOfflineAt = %v, want %v", got, offlineAt)
+	}
+
+	// Replacing all services removes the ones left out
+	if err := s.SaveServices(map[string]*Service{"db": db}); err != nil {
+		t.Fatalf("SaveServices failed: %v", err)
+	}
+	services, err = s.LoadServices()
+	if err != nil || len(services) != 1 || services["db"] == nil {
+		t.Errorf("LoadServices after replacing = %v, %v; want only db", services, err)
+	}
+}
+
+func testSQLStoreDeleteService(t *testing.T, s *SQLStore) {
+	for _, id := range []string{"web", "api"} {
+		if err := s.SaveService(&Service{ID: id, Name: id}); err != nil {
+			t.Fatalf("SaveService failed: %v", err)
+		}
+		if err := s.SaveCheckResult(&CheckResult{ServiceID: id, Status: "online", ResponseTime: 10, Timestamp: testTime(0)}); err != nil {
+			t.Fatalf("SaveCheckResult failed: %v", err)
+		}
+		if err := s.SaveIncident(&Incident{ID: id + "-incident", ServiceID: id, StartedAt: testTime(-time.Hour)}); err != nil {
+			t.Fatalf("SaveIncident failed: %v", err)
+		}
+	}
+
+	if err := s.DeleteService("web"); err != nil {
+		t.Fatalf("DeleteService failed: %v", err)
+	}
+
+	services, err := s.LoadServices()
+	if err != nil || len(services) != 1 || services["api"] == nil {
+		t.Errorf("LoadServices after delete = %v, %v; want only api", services, err)
+	}
+	incidents, err := s.LoadIncidents()
+	if err != nil || len(incidents) != 1 || incidents[0].ServiceID != "api" {
+		t.Errorf("LoadIncidents after delete = %v, %v; want only the incident of api", incidents, err)
+	}
+	history, err := s.LoadCheckHistory()
+	if err != nil || len(history.CheckResults) != 1 || history.CheckResults[0].ServiceID != "api" {
+		t.Errorf("LoadCheckHistory after delete = %v, %v; want only the check of api", history, err)
+	}
+}
+
+func testSQLStoreSaveServicesRemovesHistory(t *testing.T, s *SQLStore) {
+	archive := &BackupArchive{
+		Services: []*Service{{ID: "web", Name: "Web"}, {ID: "api", Name: "API"}},
+		Incidents: []*Incident{
+			{ID: "1", ServiceID: "web", StartedAt: testTime(-time.Hour)},
+			{ID: "2", ServiceID: "api", StartedAt: testTime(-time.Hour)},
+		},
+		NotificationConfig: &NotificationConfig{},
+		MaintenanceWindows: []*MaintenanceWindow{
+			{ID: "all", StartsAt: testTime(time.Hour), EndsAt: testTime(2 * time.Hour)},
+			{ID: "web", ServiceID: "web", StartsAt: testTime(time.Hour), EndsAt: testTime(2 * time.Hour)},
+		},
+		History: &BackupHistory{
+			CheckResults: []*CheckResult{
+				{ServiceID: "web", Status: "online", Timestamp: testTime(0)},
+				{ServiceID: "api", Status: "online", Timestamp: testTime(0)},
+			},
+			Rollups: []*CheckRollup{{ServiceID: "web", Period: rollupDay, BucketStart: startOfDay(time.Now()), Checks: 1}},
+		},
+	}
+	if err := s.Restore(archive); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	if err := s.SaveServices(map[string]*Service{"api": {ID: "api", Name: "API"}}); err != nil {
+		t.Fatalf("SaveServices failed: %v", err)
+	}
+
+	incidents, err := s.LoadIncidents()
+	if err != nil || len(incidents) != 1 || incidents[0].ServiceID != "api" {
+		t.Errorf("incidents = %v, %v; want only the incident of api", incidents, err)
+	}
+	history, err := s.LoadCheckHistory()
+	if err != nil || len(history.CheckResults) != 1 || history.CheckResults[0].ServiceID != "api" || len(history.Rollups) != 0 {
+		t.Errorf("check history = %+v, %v; want only the check of api", history, err)
+	}
+	windows, err := s.LoadMaintenanceWindows()
+	if err != nil || len(windows) != 1 || windows[0].ID != "all" {
+		t.Errorf("maintenance windows = %v, %v; want only the window for all services", windows, err)
+	}
+}
+
+func testSQLStoreIncidents(t *testing.T, s *SQLStore) {
+	older := &Incident{ID: "1", ServiceID: "web", StartedAt: testTime(-2 * time.Hour), Error: "timeout"}
+	newer := &Incident{ID: "2", ServiceID: "api", StartedAt: testTime(-time.Hour)}
+	for _, incident := range []*Incident{newer, older} {
+		if err := s.SaveIncident(incident); err != nil {
+			t.Fatalf("SaveIncident failed: %v", err)
+		}
+	}
+
+	// Saving an incident again resolves it
+	resolvedAt := testTime(0)
+	resolved := *older
+	resolved.ResolvedAt = &resolvedAt
+	if err := s.SaveIncident(&resolved); err != nil {
+		t.Fatalf("SaveIncident failed: %v", err)
+	}
+
+	incidents, err := s.LoadIncidents()
+	if err != nil {
+		t.Fatalf("LoadIncidents failed: %v", err)
+	}
+	if len(incidents) != 2 || incidents[0].ID != "1" || incidents[1].ID != "2" {
+		t.Fatalf("LoadIncidents = %v, want incidents 1 and 2, oldest first", incidents)
+	}
+	if got := incidents[0]; got.ResolvedAt == nil || !got.ResolvedAt.Equal(resolvedAt) || got.Error != "timeout" || !got.StartedAt.Equal(older.StartedAt) {
+		t.Errorf("resolved incident = %+v, want %+v", got, resolved)
+	}
+	if incidents[1].ResolvedAt != nil {
+		t.Errorf("ongoing incident has ResolvedAt %v", incidents[1].ResolvedAt)
+	}
+
+	if err := s.SaveIncidents([]*Incident{newer}); err != nil {
+		t.Fatalf("SaveIncidents failed: %v", err)
+	}
+	incidents, err = s.LoadIncidents()
+	if err != nil || len(incidents) != 1 || incidents[0].ID != "2" {
+		t.Errorf("LoadIncidents after replacing = %v, %v; want only incident 2", incidents, err)
+	}
+}
+
+func testSQLStoreNotificationConfig(t *testing.T, s *SQLStore) {
+	config, err := s.LoadNotificationConfig()
+	if err != nil || !reflect.DeepEqual(config, &NotificationConfig{}) {
+		t.Fatalf("LoadNotificationConfig on a new database = %+v, %v; want an empty config", config, err)
+	}
+
+	for _, want := range []*NotificationConfig{
+		{UserKey: "user", AppToken: "token", Enabled: true},
+		{WebhookURL: "https://hooks.example.com/gjallarhorn", Enabled: false},
+	} {
+		if err := s.SaveNotificationConfig(want); err != nil {
+			t.Fatalf("SaveNotificationConfig failed: %v", err)
+		}
+		config, err := s.LoadNotificationConfig()
+		if err != nil || !reflect.DeepEqual(config, want) {
+			t.Errorf("LoadNotificationConfig = %+v, %v; want %+v", config, err, want)
+		}
+	}
+}
+
+func testSQLStoreNotificationDeliveries(t *testing.T, s *SQLStore) {
+	deliveries := []*NotificationDelivery{
+		{ID: "old-delivered", Status: deliveryDelivered, CreatedAt: testTime(-10 * 24 * time.Hour)},
+		{ID: "old-failed", Status: deliveryFailed, CreatedAt: testTime(-9 * 24 * time.Hour)},
+		{ID: "old-pending", Status: deliveryPending, CreatedAt: testTime(-8 * 24 * time.Hour)},
+		{ID: "new-delivered", Status: deliveryDelivered, CreatedAt: testTime(-time.Hour)},
+		{ID: "new-pending", Status: deliveryPending, CreatedAt: testTime(0), Event: "down", Attempts: 1},
+	}
+	for _, delivery := range deliveries {
+		if err := s.SaveNotificationDelivery(delivery); err != nil {
+			t.Fatalf("SaveNotificationDelivery failed: %v", err)
+		}
+	}
+
+	// Saving a delivery again updates it
+	updated := *deliveries[4]
+	updated.Attempts = 2
+	updated.LastError = "HTTP 500"
+	if err := s.SaveNotificationDelivery(&updated); err != nil {
+		t.Fatalf("SaveNotificationDelivery failed: %v", err)
+	}
+
+	// Pending deliveries are kept however old they are
+	if err := s.PruneNotificationDeliveries(testTime(-7 * 24 * time.Hour)); err != nil {
+		t.Fatalf("PruneNotificationDeliveries failed: %v", err)
+	}
+
+	loaded, err := s.LoadNotificationDeliveries()
+	if err != nil {
+		t.Fatalf("LoadNotificationDeliveries failed: %v", err)
+	}
+	var ids []string
+	for _, delivery := range loaded {
+		ids = append(ids, delivery.ID)
+	}
+	if want := []string{"old-pending", "new-delivered", "new-pending"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("deliveries after pruning = %v, want %v", ids, want)
+	}
+	if got := loaded[2]; got.Attempts != 2 || got.LastError != "HTTP 500" || got.Event != "down" {
+		t.Errorf("updated delivery = %+v, want %+v", got, updated)
+	}
+}
+
+func testSQLStoreNotificationAttempts(t *testing.T, s *SQLStore) {
+	attempts := []*NotificationAttempt{
+		{ID: "old", Channel: channelPushover, Event: "down", AttemptedAt: testTime(-8 * 24 * time.Hour)},
+		{ID: "failed", Channel: channelPushover, Event: "down", StatusCode: 401, Error: "invalid token", AttemptedAt: testTime(-time.Hour)},
+		{ID: "fallback", Channel: channelWebhook, Event: "down", StatusCode: 200, Fallback: true, AttemptedAt: testTime(0)},
+	}
+	for _, attempt := range attempts {
+		if err := s.SaveNotificationAttempt(attempt); err != nil {
+			t.Fatalf("SaveNotificationAttempt failed: %v", err)
+		}
+	}
+
+	if err := s.PruneNotificationAttempts(testTime(-7 * 24 * time.Hour)); err != nil {
+		t.Fatalf("PruneNotificationAttempts failed: %v", err)
+	}
+
+	loaded, err := s.LoadNotificationAttempts()
+	if err != nil {
+		t.Fatalf("LoadNotificationAttempts failed: %v", err)
+	}
+	if len(loaded) != 2 {
+		t.Fatalf("LoadNotificationAttempts returned %d attempts, want 2", len(loaded))
+	}
+	for i, want := range attempts[1:] {
+		got := loaded[i]
+		if got.ID != want.ID || got.Channel != want.Channel || got.StatusCode != want.StatusCode ||
+			got.Error != want.Error || got.Fallback != want.Fallback || !got.AttemptedAt.Equal(want.AttemptedAt) {
+			t.Errorf("attempt %d = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func testSQLStoreAuth(t *testing.T, s *SQLStore) {
+	config, err := s.LoadAuthConfig()
+	if err != nil || config != nil {
+		t.Fatalf("LoadAuthConfig on a new database = %+v, %v; want nil", config, err)
+	}
+	keys, err := s.LoadAPIKeys()
+	if err != nil || len(keys) != 0 {
+		t.Fatalf("LoadAPIKeys on a new database = %v, %v; want none", keys, err)
+	}
+
+	wantConfig := &AuthConfig{Username: "admin", PasswordHash: "$2a$10$hash"}
+	if err := s.SaveAuthConfig(wantConfig); err != nil {
+		t.Fatalf("SaveAuthConfig failed: %v", err)
+	}
+	if config, err := s.LoadAuthConfig(); err != nil || !reflect.DeepEqual(config, wantConfig) {
+		t.Errorf("LoadAuthConfig = %+v, %v; want %+v", config, err, wantConfig)
+	}
+
+	wantKeys := []*APIKey{{ID: "1", Name: "ci", Prefix: "gjh_abc", Hash: "hash", Scopes: []string{ScopeRead}, CreatedAt: time.Unix(1700000000, 0).UTC()}}
+	if err := s.SaveAPIKeys(wantKeys); err != nil {
+		t.Fatalf("SaveAPIKeys failed: %v", err)
+	}
+	if keys, err := s.LoadAPIKeys(); err != nil || !reflect.DeepEqual(keys, wantKeys) {
+		t.Errorf("LoadAPIKeys = %+v, %v; want %+v", keys, err, wantKeys)
+	}
+}
+
+func testSQLStoreCompactHistory(t *testing.T, s *SQLStore) {
+	now := time.Now()
+	oldHour := startOfDay(now.Add(-10 * 24 * time.Hour)).Add(time.Hour)
+	for i, result := range []*CheckResult{
+		{ServiceID: "web", Status: "online", ResponseTime: 100},
+		{ServiceID: "web", Status: "online", ResponseTime: 200},
+		{ServiceID: "web", Status: "failed", ResponseTime: 30000, Error: "timeout"},
+		{ServiceID: "web", Status: "online", ResponseTime: 50, Timestamp: testTime(0)},
+	} {
+		if result.Timestamp.IsZero() {
+			result.Timestamp = oldHour.Add(time.Duration(i) * time.Minute)
+		}
+		if err := s.SaveCheckResult(result); err != nil {
+			t.Fatalf("SaveCheckResult failed: %v", err)
+		}
+	}
+
+	// Hourly rollups of the old day are already past their retention
+	policy := RetentionPolicy{Raw: 7 * 24 * time.Hour, Hourly: 5 * 24 * time.Hour, Daily: 365 * 24 * time.Hour}
+	if err := s.CompactHistory(policy, now); err != nil {
+		t.Fatalf("CompactHistory failed: %v", err)
+	}
+
+	history, err := s.LoadCheckHistory()
+	if err != nil {
+		t.Fatalf("LoadCheckHistory failed: %v", err)
+	}
+	if len(history.CheckResults) != 1 || history.CheckResults[0].ResponseTime != 50 {
+		t.Errorf("raw check results after compaction = %v, want only the recent one", history.CheckResults)
+	}
+	if len(history.Rollups) != 1 {
+		t.Fatalf("rollups after compaction = %v, want one daily rollup", history.Rollups)
+	}
+	want := CheckRollup{ServiceID: "web", Period: rollupDay, Checks: 3, Successes: 2, Failures: 1,
+		MinResponseTime: 100, AvgResponseTime: 150, MaxResponseTime: 200}
+	got := *history.Rollups[0]
+	if !got.BucketStart.Equal(startOfDay(oldHour)) {
+		t.Errorf("daily rollup starts at %v, want %v", got.BucketStart, startOfDay(oldHour))
+	}
+	got.BucketStart, got.P95ResponseTime = time.Time{}, 0
+	if got != want {
+		t.Errorf("daily rollup = %+v, want %+v", got, want)
+	}
+
+	// Compacting again finds nothing left to roll up
+	if err := s.CompactHistory(policy, now); err != nil {
+		t.Fatalf("second CompactHistory failed: %v", err)
+	}
+	if again, err := s.LoadCheckHistory(); err != nil || len(again.CheckResults) != 1 || len(again.Rollups) != 1 {
+		t.Errorf("history after compacting again = %v, %v; want it unchanged", again, err)
+	}
+
+	// Results written late for a day already rolled up are added to its rollup
+	for i, result := range []*CheckResult{
+		{ServiceID: "web", Status: "online", ResponseTime: 400},
+		{ServiceID: "web", Status: "failed", Error: "timeout"},
+	} {
+		result.Timestamp = oldHour.Add(time.Duration(30+i) * time.Minute)
+		if err := s.SaveCheckResult(result); err != nil {
+			t.Fatalf("SaveCheckResult failed: %v", err)
+		}
+	}
+	if err := s.CompactHistory(policy, now); err != nil {
+		t.Fatalf("CompactHistory of late results failed: %v", err)
+	}
+	history, err = s.LoadCheckHistory()
+	if err != nil || len(history.Rollups) != 1 {
+		t.Fatalf("rollups after compacting late results = %v, %v; want one daily rollup", history, err)
+	}
+	want = CheckRollup{ServiceID: "web", Period: rollupDay, Checks: 5, Successes: 3, Failures: 2,
+		MinResponseTime: 100, AvgResponseTime: 233, MaxResponseTime: 400, P95ResponseTime: 400}
+	got = *history.Rollups[0]
+	got.BucketStart = time.Time{}
+	if got != want {
+		t.Errorf("daily rollup after compacting late results = %+v, want %+v", got, want)
+	}
+}
+
+func testSQLStoreRestore(t *testing.T, s *SQLStore) {
+	for _, id := range []string{"kept", "removed"} {
+		if err := s.SaveService(&Service{ID: id, Name: id}); err != nil {
+			t.Fatalf("SaveService failed: %v", err)
+		}
+		if err := s.SaveCheckResult(&CheckResult{ServiceID: id, Status: "online", Timestamp: testTime(0)}); err != nil {
+			t.Fatalf("SaveCheckResult failed: %v", err)
+		}
+	}
+	if err := s.SaveIncident(&Incident{ID: "stale", ServiceID: "removed", StartedAt: testTime(-time.Hour)}); err != nil {
+		t.Fatalf("SaveIncident failed: %v", err)
+	}
+
+	archive := &BackupArchive{
+		Services:           []*Service{{ID: "kept", Name: "Kept"}, {ID: "new", Name: "New"}},
+		Incidents:          []*Incident{{ID: "restored", ServiceID: "kept", StartedAt: testTime(-2 * time.Hour)}},
+		NotificationConfig: &NotificationConfig{UserKey: "user", AppToken: "token", Enabled: true},
+		MaintenanceWindows: []*MaintenanceWindow{
+			{ID: "all", StartsAt: testTime(time.Hour), EndsAt: testTime(2 * time.Hour), Reason: "upgrade"},
+			{ID: "one", ServiceID: "kept", StartsAt: testTime(3 * time.Hour), EndsAt: testTime(4 * time.Hour)},
+		},
+	}
+	if err := s.Restore(archive); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	services, err := s.LoadServices()
+	if err != nil || len(services) != 2 || services["kept"].Name != "Kept" || services["new"] == nil {
+		t.Errorf("services after restore = %v, %v; want kept and new", services, err)
+	}
+	incidents, err := s.LoadIncidents()
+	if err != nil || len(incidents) != 1 || incidents[0].ID != "restored" {
+		t.Errorf("incidents after restore = %v, %v; want only the restored one", incidents, err)
+	}
+	if config, err := s.LoadNotificationConfig(); err != nil || !reflect.DeepEqual(config, archive.NotificationConfig) {
+		t.Errorf("notification config after restore = %+v, %v; want %+v", config, err, archive.NotificationConfig)
+	}
+	windows, err := s.LoadMaintenanceWindows()
+	if err != nil || len(windows) != 2 || windows[0].ServiceID != "" || windows[1].ServiceID != "kept" || windows[0].Reason != "upgrade" {
+		t.Errorf("maintenance windows after restore = %v, %v; want both windows", windows, err)
+	}
+
+	// Without history in the archive, only the history of removed services is dropped
+	history, err := s.LoadCheckHistory()
+	if err != nil || len(history.CheckResults) != 1 || history.CheckResults[0].ServiceID != "kept" {
+		t.Errorf("check history after restore = %v, %v; want only the check of kept", history, err)
+	}
+
+	// With history, it replaces the stored history
+	archive.History = &BackupHistory{
+		CheckResults: []*CheckResult{{ServiceID: "new", Status: "failed", Timestamp: testTime(-time.Minute)}},
+		Rollups:      []*CheckRollup{{ServiceID: "new", Period: rollupDay, BucketStart: startOfDay(time.Now()), Checks: 5, Successes: 5}},
+	}
+	if err := s.Restore(archive); err != nil {
+		t.Fatalf("Restore with history failed: %v", err)
+	}
+	history, err = s.LoadCheckHistory()
+	if err != nil || len(history.CheckResults) != 1 || history.CheckResults[0].ServiceID != "new" ||
+		len(history.Rollups) != 1 || history.Rollups[0].Checks != 5 {
+		t.Errorf("check history after restore with history = %+v, %v; want the archive's", history, err)
+	}
+}
+
+func testSQLStorePing(t *testing.T, s *SQLStore) {
+	if err := s.Ping(); err != nil {
+		t.Errorf("Ping failed: %v", err)
+	}
+	s.Close()
+	if err := s.Ping(); err == nil {
+		t.Error("Ping succeeded on a closed database")
+	}
+}
+
+func TestNewSQLStoreImportsJSONFilesOnce(t *testing.T) {
+	forEachSQLDatabase(t, func(t *testing.T, dialect, dsn string) {
+		dataDir := t.TempDir()
+		files := NewFileStore(dataDir)
+		if err := files.SaveServices(map[string]*Service{"web": {ID: "web", Name: "Web", URL: "https://example.com", Interval: 60}}); err != nil {
+			t.Fatalf("SaveServices failed: %v", err)
+		}
+		if err := files.SaveIncident(&Incident{ID: "1", ServiceID: "web", StartedAt: testTime(-time.Hour)}); err != nil {
+			t.Fatalf("SaveIncident failed: %v", err)
+		}
+		if err := files.SaveNotificationConfig(&NotificationConfig{UserKey: "user", AppToken: "token", Enabled: true}); err != nil {
+			t.Fatalf("SaveNotificationConfig failed: %v", err)
+		}
+
+		store, err := newSQLStoreWithImport(dialect, dsn, dataDir)
+		if err != nil {
+			t.Fatalf("newSQLStoreWithImport failed: %v", err)
+		}
+		services, err := store.LoadServices()
+		if err != nil || len(services) != 1 || services["web"].Name != "Web" {
+			t.Errorf("imported services = %v, %v; want web", services, err)
+		}
+		incidents, err := store.LoadIncidents()
+		if err != nil || len(incidents) != 1 {
+			t.Errorf("imported incidents = %v, %v; want one", incidents, err)
+		}
+		if config, err := store.LoadNotificationConfig(); err != nil || config.UserKey != "user" {
+			t.Errorf("imported notification config = %+v, %v", config, err)
+		}
+
+		// Changes made after the import are not overwritten by the JSON files on the next start
+		if err := store.DeleteService("web"); err != nil {
+			t.Fatalf("DeleteService failed: %v", err)
+		}
+		store.Close()
+
+		store, err = newSQLStoreWithImport(dialect, dsn, dataDir)
+		if err != nil {
+			t.Fatalf("reopening failed: %v", err)
+		}
+		defer store.Close()
+		if services, err := store.LoadServices(); err != nil || len(services) != 0 {
+			t.Errorf("services after reopening = %v, %v; want none, as the JSON files were already imported", services, err)
+		}
+	})
+}
+
+func TestNewSQLStoreImportsNotificationsWithoutServices(t *testing.T) {
+	forEachSQLDatabase(t, func(t *testing.T, dialect, dsn string) {
+		dataDir := t.TempDir()
+		files := NewFileStore(dataDir)
+		config := &NotificationConfig{UserKey: "user", AppToken: "token", WebhookURL: "https://hooks.example.com/gjallarhorn", Enabled: true}
+		if err := files.SaveNotificationConfig(config); err != nil {
+			t.Fatalf("SaveNotificationConfig failed: %v", err)
+		}
+		pending := &NotificationDelivery{ID: "1", Event: "down", Channel: channelPushover, Status: deliveryPending,
+			CreatedAt: testTime(-time.Minute), NextAttemptAt: testTime(time.Minute)}
+		if err := files.SaveNotificationDelivery(pending); err != nil {
+			t.Fatalf("SaveNotificationDelivery failed: %v", err)
+		}
+		if err := files.SaveNotificationAttempt(&NotificationAttempt{ID: "1", Channel: channelPushover, Event: "down", StatusCode: 400, AttemptedAt: testTime(-time.Minute)}); err != nil {
+			t.Fatalf("SaveNotificationAttempt failed: %v", err)
+		}
+
+		store, err := newSQLStoreWithImport(dialect, dsn, dataDir)
+		if err != nil {
+			t.Fatalf("newSQLStoreWithImport failed: %v", err)
+		}
+		defer store.Close()
+
+		if got, err := store.LoadNotificationConfig(); err != nil || !reflect.DeepEqual(got, config) {
+			t.Errorf("imported notification config = %+v, %v; want %+v", got, err, config)
+		}
+		deliveries, err := store.LoadNotificationDeliveries()
+		if err != nil || len(deliveries) != 1 || deliveries[0].Status != deliveryPending || !deliveries[0].NextAttemptAt.Equal(pending.NextAttemptAt) {
+			t.Errorf("imported deliveries = %+v, %v; want the pending delivery", deliveries, err)
+		}
+		if attempts, err := store.LoadNotificationAttempts(); err != nil || len(attempts) != 1 {
+			t.Errorf("imported attempts = %v, %v; want one", attempts, err)
+		}
+	})
+}
+
+func TestSQLStoreRebind(t *testing.T) {
+	query := "SELECT * FROM services WHERE id = ? AND name = ?"
+	tests := []struct {
+		dialect string
+		want    string
+	}{
+		{dialectSQLite, query},
+		{dialectPostgres, "SELECT * FROM services WHERE id = $1 AND name = $2"},
+	}
+	for _, tt := range tests {
+		s := &SQLStore{dialect: tt.dialect}
+		if got := s.rebind(query); got != tt.want {
+			t.Errorf("rebind for %s = %q, want %q", tt.dialect, got, tt.want)
+		}
+	}
+}
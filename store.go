@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Store persists services, incidents, check history and settings
+type Store interface {
+	LoadServices() (map[string]*Service, error)
+	// SaveServices replaces all services. Like DeleteService, it removes the incidents,
+	// check history and maintenance windows of services that are no longer stored.
+	SaveServices(services map[string]*Service) error
+	SaveService(service *Service) error
+	DeleteService(id string) error
+
+	LoadIncidents() ([]*Incident, error)
+	SaveIncidents(incidents []*Incident) error // Replaces all incidents
+	SaveIncident(incident *Incident) error
+
+	SaveCheckResult(result *CheckResult) error
+	CompactHistory(policy RetentionPolicy, now time.Time) error
+	LoadCheckHistory() (*BackupHistory, error)
+
+	LoadMaintenanceWindows() ([]*MaintenanceWindow, error)
+
+	LoadNotificationConfig() (*NotificationConfig, error)
+	SaveNotificationConfig(config *NotificationConfig) error
+	LoadNotificationDeliveries() ([]*NotificationDelivery, error)
+	SaveNotificationDelivery(delivery *NotificationDelivery) error
+	PruneNotificationDeliveries(before time.Time) error // Removes finished deliveries created before a time
+	LoadNotificationAttempts() ([]*NotificationAttempt, error)
+	SaveNotificationAttempt(attempt *NotificationAttempt) error
+	PruneNotificationAttempts(before time.Time) error
+
+	LoadAuthConfig() (*AuthConfig, error) // Returns nil when no admin user is configured
+	SaveAuthConfig(config *AuthConfig) error
+	LoadAPIKeys() ([]*APIKey, error)
+	SaveAPIKeys(keys []*APIKey) error
+
+	// Restore replaces services, incidents, notification config, maintenance windows
+	// and, when the archive includes it, check history, all or nothing
+	Restore(archive *BackupArchive) error
+
+	Ping() error // Checks that storage can be read and written
+	Close() error
+}
+
+// NewStore creates the store selected by STORAGE_BACKEND: "json" (default), "sqlite" or "postgres"
+func NewStore() (Store, error) {
+	dataDir := getDataDir()
+
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "json":
+		store := NewFileStore(dataDir)
+		if err := store.Migrate(); err != nil {
+			return nil, err
+		}
+		return store, nil
+	case "sqlite":
+		if err := os.MkdirAll(dataDir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create data directory: %v", err)
+		}
+		return newSQLStoreWithImport(dialectSQLite, getSQLitePath(dataDir), dataDir)
+	case "postgres":
+		dsn := os.Getenv("DATABASE_URL")
+		if dsn == "" {
+			return nil, fmt.Errorf("DATABASE_URL is required for the postgres backend")
+		}
+		return newSQLStoreWithImport(dialectPostgres, dsn, dataDir)
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND '%s', expected 'json', 'sqlite' or 'postgres'", backend)
+	}
+}
+
+// newSQLStoreWithImport opens a SQL store and, on its first start, imports the
+// JSON files from the data directory so switching backends keeps all data
+func newSQLStoreWithImport(dialect, dsn, dataDir string) (*SQLStore, error) {
+	store, err := NewSQLStore(dialect, dsn, filepath.Join(dataDir, "backups"))
+	if err != nil {
+		return nil, err
+	}
+
+	imported, err := store.jsonImported()
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	if imported {
+		return store, nil
+	}
+
+	files := NewFileStore(dataDir)
+	if files.hasDataFiles() {
+		if err := files.Migrate(); err != nil {
+			store.Close()
+			return nil, err
+		}
+		if err := copyStore(store, files); err != nil {
+			store.Close()
+			return nil, fmt.Errorf("failed to import JSON data: %v", err)
+		}
+	}
+	if err := store.markJSONImported(); err != nil {
+		store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// copyStore copies services, incidents, settings and the notification delivery queue and
+// log from one store to another. Check history is not copied.
+func copyStore(dst, src Store) error {
+	services, err := src.LoadServices()
+	if err != nil {
+		return err
+	}
+	incidents, err := src.LoadIncidents()
+	if err != nil {
+		return err
+	}
+	notificationConfig, err := src.LoadNotificationConfig()
+	if err != nil {
+		return err
+	}
+	authConfig, err := src.LoadAuthConfig()
+	if err != nil {
+		return err
+	}
+	keys, err := src.LoadAPIKeys()
+	if err != nil {
+		return err
+	}
+	deliveries, err := src.LoadNotificationDeliveries()
+	if err != nil {
+		return err
+	}
+	attempts, err := src.LoadNotificationAttempts()
+	if err != nil {
+		return err
+	}
+
+	if err := dst.SaveServices(services); err != nil {
+		return err
+	}
+	if err := dst.SaveIncidents(incidents); err != nil {
+		return err
+	}
+	if err := dst.SaveNotificationConfig(notificationConfig); err != nil {
+		return err
+	}
+	if authConfig != nil {
+		if err := dst.SaveAuthConfig(authConfig); err != nil {
+			return err
+		}
+	}
+	if err := dst.SaveAPIKeys(keys); err != nil {
+		return err
+	}
+	pending := 0
+	for _, delivery := range deliveries {
+		if err := dst.SaveNotificationDelivery(delivery); err != nil {
+			return err
+		}
+		if delivery.Status == deliveryPending {
+			pending++
+		}
+	}
+	for _, attempt := range attempts {
+		if err := dst.SaveNotificationAttempt(attempt); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Copied %d services, %d incidents and %d notification deliveries (%d pending) into the new store",
+		len(services), len(incidents), len(deliveries), pending)
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// watchdogCheckInterval is how often the watchdog looks at the monitoring loop
+const watchdogCheckInterval = 30 * time.Second
+
+// Watchdog alerts when rounds of checks stop completing, for example because a check or
+// notification is stuck while holding the monitor's lock, and pings an external
+// dead-man's switch while they do complete
+type Watchdog struct {
+	monitor       *MonitorService
+	notifications *NotificationService
+	threshold     time.Duration // How long without a completed round counts as stalled
+	heartbeatURL  string
+	client        *http.Client
+	stalledSince  time.Time // Last completed round when a stall was reported; zero while healthy
+}
+
+// NewWatchdog creates a watchdog using WATCHDOG_THRESHOLD and HEARTBEAT_URL
+func NewWatchdog(monitor *MonitorService, notifications *NotificationService) *Watchdog {
+	return &Watchdog{
+		monitor:       monitor,
+		notifications: notifications,
+		threshold:     getWatchdogThreshold(monitor.checkInterval),
+		heartbeatURL:  os.Getenv("HEARTBEAT_URL"),
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// getWatchdogThreshold returns the stall threshold from WATCHDOG_THRESHOLD in seconds. The default
+// of three check intervals, but at least 5 minutes, leaves time for rounds with slow services.
+func getWatchdogThreshold(checkInterval time.Duration) time.Duration {
+	threshold := 3 * checkInterval
+	if threshold < 5*time.Minute {
+		threshold = 5 * time.Minute
+	}
+
+	thresholdStr := os.Getenv("WATCHDOG_THRESHOLD")
+	if thresholdStr == "" {
+		return threshold
+	}
+	seconds, err := strconv.Atoi(thresholdStr)
+	if err != nil || seconds < 1 {
+		log.Printf("Invalid WATCHDOG_THRESHOLD '%s', using default %v", thresholdStr, threshold)
+		return threshold
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Start watches the monitoring loop until ctx is done. It only reads the time of the last
+// completed round, so it keeps working when the monitor's lock is held forever.
+func (w *Watchdog) Start(ctx context.Context) {
+	log.Printf("Starting watchdog: alerting when no round of checks completes for %v", w.threshold)
+	if w.heartbeatURL != "" {
+		log.Printf("Sending heartbeats every %v while checks complete", watchdogCheckInterval)
+	}
+
+	ticker := time.NewTicker(watchdogCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if w.check(time.Now()) && w.heartbeatURL != "" {
+				w.sendHeartbeat(ctx)
+			}
+		}
+	}
+}
+
+// check alerts when checks have stalled, and once when they recover. It reports whether
+// monitoring is healthy.
+func (w *Watchdog) check(now time.Time) bool {
+	lastRound := w.monitor.lastRound.Load()
+	if lastRound == 0 {
+		return false // Monitoring hasn't started yet
+	}
+	completedAt := time.Unix(0, lastRound)
+	stalledFor := now.Sub(completedAt)
+
+	if stalledFor > w.threshold {
+		if w.stalledSince.IsZero() {
+			w.stalledSince = completedAt
+			log.Printf("Error: Watchdog: no round of checks has completed for %v", stalledFor.Round(time.Second))
+			w.notifications.SendWatchdogAlert(stalledFor)
+		}
+		return false
+	}
+
+	if !w.stalledSince.IsZero() {
+		stall := completedAt.Sub(w.stalledSince)
+		w.stalledSince = time.Time{}
+		log.Printf("Watchdog: checks are completing again after %v", stall.Round(time.Second))
+		w.notifications.SendWatchdogRecovery(stall)
+	}
+	return true
+}
+
+// sendHeartbeat pings the dead-man's switch, which alerts when the pings stop
+func (w *Watchdog) sendHeartbeat(ctx context.Context) {
+	req, err := http.NewRequestWithContext(ctx, "GET", w.heartbeatURL, nil)
+	if err != nil {
+		log.Printf("Warning: Invalid HEARTBEAT_URL: %v", err)
+		return
+	}
+	req.Header.Set("User-Agent", "Gjallarhorn/1.0")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		log.Printf("Warning: Failed to send heartbeat: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Warning: Heartbeat URL returned HTTP %d", resp.StatusCode)
+	}
+}